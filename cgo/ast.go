@@ -12,13 +12,14 @@ import (
 	"go/parser"
 	"go/scanner"
 	"go/token"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-func parse(name string, flags parser.Mode) *ast.File {
-	ast1, err := parser.ParseFile(fset, name, nil, flags)
+func parse(name string, src []byte, flags parser.Mode) *ast.File {
+	ast1, err := parser.ParseFile(fset, name, src, flags)
 	if err != nil {
 		if list, ok := err.(scanner.ErrorList); ok {
 			// If err is a scanner.ErrorList, its String will print just
@@ -45,12 +46,35 @@ func sourceLine(n ast.Node) int {
 // a list of exported functions, and the actual AST, to be rewritten and
 // printed.
 func (f *File) ReadGo(name string) {
-	// Create absolute path for file, so that it will be used in error
-	// messages and recorded in debug line number information.
-	// This matches the rest of the toolchain. See golang.org/issue/5122.
+	f.readGo(name, absPath(name))
+}
+
+// ReadFile returns a new File read from the Go source file name,
+// which is recorded in the output as p.Options say.
+func (p *Package) ReadFile(name string) *File {
+	f := new(File)
+	f.readGo(name, p.trimPath(absPath(name)))
+	return f
+}
+
+// absPath returns the absolute path for file name, so that it will be
+// used in error messages and recorded in debug line number information.
+// This matches the rest of the toolchain. See golang.org/issue/5122.
+func absPath(name string) string {
 	if aname, err := filepath.Abs(name); err == nil {
-		name = aname
+		return aname
+	}
+	return name
+}
+
+// readGo implements ReadGo, reading the file name
+// and recording it as recorded.
+func (f *File) readGo(name, recorded string) {
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		fatalf("%s", err)
 	}
+	name = recorded
 
 	// Two different parses: once with comments, once without.
 	// The printer is not good enough at printing comments in the
@@ -58,8 +82,8 @@ func (f *File) ReadGo(name string) {
 	// so we use ast1 to look for the doc comments on import "C"
 	// and on exported functions, and we use ast2 for translating
 	// and reprinting.
-	ast1 := parse(name, parser.ParseComments)
-	ast2 := parse(name, 0)
+	ast1 := parse(name, src, parser.ParseComments)
+	ast2 := parse(name, src, 0)
 
 	f.Package = ast1.Name.Name
 	f.Name = make(map[string]*Name)
@@ -163,6 +187,15 @@ func commentText(g *ast.CommentGroup) string {
 	return strings.Join(pieces, "")
 }
 
+// isC reports whether x refers to the pseudo-package C.  The parser
+// resolves identifiers declared in the file, such as a local variable
+// or parameter named C, but not imported package names, and import "C"
+// cannot be renamed: so C refers to the import if it is unresolved.
+func isC(x ast.Expr) bool {
+	id, ok := x.(*ast.Ident)
+	return ok && id.Name == "C" && id.Obj == nil
+}
+
 // Save references to C.xxx for later processing.
 func (f *File) saveRef(x interface{}, context string) {
 	n, ok := x.(*ast.Expr)
@@ -170,12 +203,7 @@ func (f *File) saveRef(x interface{}, context string) {
 		return
 	}
 	if sel, ok := (*n).(*ast.SelectorExpr); ok {
-		// For now, assume that the only instance of capital C is
-		// when used as the imported package identifier.
-		// The parser should take care of scoping in the future,
-		// so that we will be able to distinguish a "top-level C"
-		// from a local C.
-		if l, ok := sel.X.(*ast.Ident); ok && l.Name == "C" {
+		if isC(sel.X) {
 			if context == "as2" {
 				context = "expr"
 			}
@@ -0,0 +1,1741 @@
+// Determine the kinds and types of C names by parsing the
+// preprocessed preamble directly, instead of compiling probe
+// programs with gcc and reading back the DWARF debug information.
+//
+// The parser understands the declaration subset of C that appears in
+// headers: typedefs, struct, union and enum definitions, function
+// prototypes and extern variables, along with the gcc extensions that
+// system headers commonly use.  Function bodies and initializers are
+// skipped.  It synthesizes debug/dwarf types so that the rest of cgo
+// converts them exactly as it would the compiler's debug output.
+
+package cgo
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"fmt"
+	"go/token"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// loadHeaders determines the kinds and types of the names in f by
+// parsing the C preamble.  It is the Package.ParseHeaders counterpart
+// of guessKinds and loadDWARF.  The compiler is still used as a
+// preprocessor, but never to compile anything.
+func (p *Package) loadHeaders(f *File) {
+	var b bytes.Buffer
+	b.WriteString(f.Preamble)
+	b.WriteString(builtinProlog)
+	hp := newHeaderParser(p)
+	hp.parse(p.gccPreprocess(b.Bytes()))
+	hp.defines = f.defines
+
+	nameToRef := make(map[*Name]*Ref)
+	for _, ref := range f.Ref {
+		nameToRef[ref.Name] = ref
+	}
+
+	var conv typeConv
+	conv.Init(p)
+	for _, key := range nameKeys(f.Name) {
+		n := f.Name[key]
+		if n.Kind == "macro" {
+			continue
+		}
+		pos := token.NoPos
+		if ref, ok := nameToRef[n]; ok {
+			pos = ref.Pos()
+		}
+		if n.Define != "" {
+			if p.defineConst(n, hp) {
+				continue
+			}
+			if isName(n.Define) {
+				n.C = n.Define
+			}
+		}
+		if !hp.resolve(n, &conv, pos) {
+			error_(pos, "could not determine kind of name for C.%s", fixGo(n.Go))
+		}
+		conv.FinishType(pos)
+	}
+	if nerrors > 0 {
+		fatalf("unresolved names")
+	}
+
+	// Describe every enum with p.AllEnums, and otherwise the
+	// enums with an enumerator we refer to.
+	referenced := make(map[string]bool)
+	for _, n := range f.Name {
+		referenced[n.C] = true
+	}
+	for _, t := range hp.enumTypes() {
+		if !p.AllEnums && !refersToEnum(t, referenced) {
+			continue
+		}
+		conv.Type(t, token.NoPos)
+		conv.FinishType(token.NoPos)
+	}
+}
+
+// refersToEnum reports whether one of the enumerators of the
+// enum type t, or of the enum t names, is in referenced.
+func refersToEnum(t dwarf.Type, referenced map[string]bool) bool {
+	if td, ok := t.(*dwarf.TypedefType); ok {
+		t = td.Type
+	}
+	et, ok := t.(*dwarf.EnumType)
+	if !ok {
+		return false
+	}
+	for _, ev := range et.Val {
+		if referenced[ev.Name] {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve fills in the kind and type of n from the parsed headers.
+// It reports whether n could be resolved.
+func (hp *headerParser) resolve(n *Name, conv *typeConv, pos token.Pos) bool {
+	if isName(n.C) {
+		if v, ok := hp.enumerators[n.C]; ok {
+			n.Kind = "const"
+			n.Const = fmt.Sprintf("%#x", v)
+			n.Type = conv.Type(hp.basic("int"), pos)
+			return true
+		}
+		if ft, ok := hp.funcs[n.C]; ok {
+			n.Kind = "func"
+			n.FuncType = conv.FuncType(ft, pos)
+			return true
+		}
+		if t, ok := hp.vars[n.C]; ok {
+			n.Kind = "var"
+			n.Type = conv.Type(t, pos)
+			return true
+		}
+	}
+	// The preprocessor has already been run, so a macro
+	// is resolved by resolving its expansion.
+	src := n.C
+	if n.Define != "" {
+		src = n.Define
+	}
+	if t, ok := hp.parseTypeName(src); ok {
+		n.Kind = "type"
+		if t != nil {
+			n.Type = conv.Type(t, pos)
+		}
+		return true
+	}
+	if v, ok := hp.evalExpr(src); ok {
+		n.Kind = "const"
+		n.Const = fmt.Sprintf("%#x", v)
+		return true
+	}
+	return false
+}
+
+// A headerParser parses preprocessed C declarations
+// and records the names they declare.
+type headerParser struct {
+	p    *Package
+	toks []cToken
+	pos  int
+
+	basics      map[string]dwarf.Type
+	ptrs        map[dwarf.Type]*dwarf.PtrType
+	typedefs    map[string]*dwarf.TypedefType
+	tags        map[string]dwarf.Type // "struct x", "union x", "enum x"
+	align       map[dwarf.Type]int64  // alignment of structs and aligned typedefs
+	enumerators map[string]int64
+	funcs       map[string]*dwarf.FuncType
+	vars        map[string]dwarf.Type
+	defines     map[string]string // object-like macros, for expanding constants
+	expanding   map[string]bool
+}
+
+func newHeaderParser(p *Package) *headerParser {
+	return &headerParser{
+		p:           p,
+		basics:      make(map[string]dwarf.Type),
+		ptrs:        make(map[dwarf.Type]*dwarf.PtrType),
+		typedefs:    make(map[string]*dwarf.TypedefType),
+		tags:        make(map[string]dwarf.Type),
+		align:       make(map[dwarf.Type]int64),
+		enumerators: make(map[string]int64),
+		funcs:       make(map[string]*dwarf.FuncType),
+		vars:        make(map[string]dwarf.Type),
+		defines:     make(map[string]string),
+		expanding:   make(map[string]bool),
+	}
+}
+
+// A cParseError is the panic value used to abandon a declaration
+// the parser does not understand.
+type cParseError string
+
+func (hp *headerParser) fail(format string, args ...interface{}) {
+	panic(cParseError(fmt.Sprintf(format, args...)))
+}
+
+// parse parses the preprocessed C source src, recording its declarations.
+// Declarations that cannot be parsed are skipped.
+func (hp *headerParser) parse(src string) {
+	hp.toks = cTokenize(src)
+	hp.pos = 0
+	for hp.pos < len(hp.toks) {
+		start := hp.pos
+		if !hp.try(hp.declaration) {
+			hp.pos = start
+			hp.skipDeclaration()
+		}
+	}
+}
+
+// try runs fn, reporting whether it completed without a parse error.
+func (hp *headerParser) try(fn func()) (ok bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			if _, isParseError := e.(cParseError); !isParseError {
+				panic(e)
+			}
+			ok = false
+		}
+	}()
+	fn()
+	return true
+}
+
+// skipDeclaration skips to the end of the current declaration:
+// the next top-level semicolon, or the closing brace of a function body.
+func (hp *headerParser) skipDeclaration() {
+	depth := 0
+	for hp.pos < len(hp.toks) {
+		t := hp.toks[hp.pos]
+		hp.pos++
+		if t.kind != 'p' {
+			continue
+		}
+		switch t.text {
+		case "(", "[", "{":
+			depth++
+		case ")", "]":
+			depth--
+		case "}":
+			depth--
+			if depth == 0 && hp.closesBody(hp.pos-1) {
+				return
+			}
+		case ";":
+			if depth <= 0 {
+				return
+			}
+		}
+	}
+}
+
+// closesBody reports whether the brace at toks[i] closes
+// a block that directly follows a parameter list, that is,
+// a function body.
+func (hp *headerParser) closesBody(i int) bool {
+	depth := 0
+	for ; i >= 0; i-- {
+		switch hp.toks[i].text {
+		case "}":
+			depth++
+		case "{":
+			depth--
+			if depth == 0 {
+				return i > 0 && hp.toks[i-1].text == ")"
+			}
+		}
+	}
+	return false
+}
+
+// subParse runs fn over the tokens of src rather than the header,
+// reporting whether it consumed all of src without a parse error.
+func (hp *headerParser) subParse(src string, fn func()) bool {
+	toks, pos := hp.toks, hp.pos
+	defer func() {
+		hp.toks, hp.pos = toks, pos
+	}()
+	hp.toks = cTokenize(src)
+	hp.pos = 0
+	for i, t := range hp.toks {
+		// Go spells C99's "double complex" as C.complexdouble,
+		// which cname turns back into "double complex".
+		if t.kind == 'i' && t.text == "complex" && hp.typedefs["complex"] == nil {
+			hp.toks[i].text = "_Complex"
+		}
+	}
+	return hp.try(fn) && hp.pos == len(hp.toks)
+}
+
+// parseTypeName parses s as a C type name.  It reports whether s is
+// a type; the type is nil for an enum tag that was never defined.
+func (hp *headerParser) parseTypeName(s string) (t dwarf.Type, ok bool) {
+	ok = hp.subParse(s, func() {
+		if !hp.isTypeStart(hp.peek()) {
+			hp.fail("not a type")
+		}
+		t = hp.typeName()
+	})
+	if et, isEnum := t.(*dwarf.EnumType); isEnum && et.Val == nil {
+		t = nil
+	}
+	return t, ok
+}
+
+// evalExpr evaluates s as an integer constant expression.
+func (hp *headerParser) evalExpr(s string) (v int64, ok bool) {
+	ok = hp.subParse(s, func() {
+		v = hp.constExpr()
+	})
+	return v, ok
+}
+
+// evalConst evaluates s as an arithmetic constant expression.
+func (hp *headerParser) evalConst(s string) (v cValue, ok bool) {
+	ok = hp.subParse(s, func() {
+		v = hp.expr()
+	})
+	return v, ok
+}
+
+// enumTypes returns the enum types defined by the headers,
+// both tagged and typedef'd, in a stable order.
+func (hp *headerParser) enumTypes() []dwarf.Type {
+	var tags, typedefs []string
+	for k := range hp.tags {
+		tags = append(tags, k)
+	}
+	for k := range hp.typedefs {
+		typedefs = append(typedefs, k)
+	}
+	sort.Strings(tags)
+	sort.Strings(typedefs)
+
+	var ts []dwarf.Type
+	for _, k := range tags {
+		if et, ok := hp.tags[k].(*dwarf.EnumType); ok && et.Val != nil {
+			ts = append(ts, et)
+		}
+	}
+	for _, k := range typedefs {
+		td := hp.typedefs[k]
+		if et, ok := td.Type.(*dwarf.EnumType); ok && et.EnumName == "" {
+			ts = append(ts, td)
+		}
+	}
+	return ts
+}
+
+// Tokens.
+
+// A cToken is a single C token.
+type cToken struct {
+	kind byte // 'i' identifier, 'n' number, 'c' character, 's' string, 'p' punctuation, 0 end of input
+	text string
+}
+
+var cPunct = []string{
+	"...", "<<=", ">>=",
+	"->", "++", "--", "<<", ">>", "<=", ">=", "==", "!=", "&&", "||",
+	"+=", "-=", "*=", "/=", "%=", "&=", "^=", "|=", "##",
+}
+
+// cTokenize splits preprocessed C source into tokens,
+// dropping line markers and any other # directives.
+func cTokenize(src string) []cToken {
+	var toks []cToken
+	lineStart := true
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			lineStart = true
+			i++
+			continue
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			i++
+			continue
+		case c == '#' && lineStart:
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			continue
+		}
+		lineStart = false
+
+		start := i
+		switch {
+		case isCIdentByte(c) && !('0' <= c && c <= '9'):
+			for i < len(src) && isCIdentByte(src[i]) {
+				i++
+			}
+			// String and character literal prefixes.
+			if i < len(src) && (src[i] == '"' || src[i] == '\'') {
+				switch src[start:i] {
+				case "L", "u", "U", "u8":
+					i = cQuoted(src, i)
+					toks = append(toks, cToken{src[i-1], src[start:i]})
+					continue
+				}
+			}
+			toks = append(toks, cToken{'i', src[start:i]})
+		case '0' <= c && c <= '9' || c == '.' && i+1 < len(src) && '0' <= src[i+1] && src[i+1] <= '9':
+			for i < len(src) {
+				d := src[i]
+				if (d == '+' || d == '-') && strings.ContainsRune("eEpP", rune(src[i-1])) {
+					i++
+					continue
+				}
+				if !isCIdentByte(d) && d != '.' {
+					break
+				}
+				i++
+			}
+			toks = append(toks, cToken{'n', src[start:i]})
+		case c == '"' || c == '\'':
+			i = cQuoted(src, i)
+			toks = append(toks, cToken{src[i-1], src[start:i]})
+		default:
+			text := src[i : i+1]
+			for _, op := range cPunct {
+				if strings.HasPrefix(src[i:], op) {
+					text = op
+					break
+				}
+			}
+			i += len(text)
+			toks = append(toks, cToken{'p', text})
+		}
+	}
+	for i := range toks {
+		switch toks[i].kind {
+		case '"':
+			toks[i].kind = 's'
+		case '\'':
+			toks[i].kind = 'c'
+		}
+	}
+	return toks
+}
+
+// cQuoted returns the index just past the quoted literal starting at src[i].
+func cQuoted(src string, i int) int {
+	q := src[i]
+	for i++; i < len(src) && src[i] != q && src[i] != '\n'; i++ {
+		if src[i] == '\\' {
+			i++
+		}
+	}
+	if i < len(src) {
+		i++
+	}
+	return i
+}
+
+func isCIdentByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '$'
+}
+
+func (hp *headerParser) peekAt(i int) cToken {
+	if hp.pos+i < len(hp.toks) {
+		return hp.toks[hp.pos+i]
+	}
+	return cToken{}
+}
+
+func (hp *headerParser) peek() cToken {
+	return hp.peekAt(0)
+}
+
+func (hp *headerParser) next() cToken {
+	t := hp.peek()
+	if t.kind == 0 {
+		hp.fail("unexpected end of input")
+	}
+	hp.pos++
+	return t
+}
+
+// is reports whether the next token is the identifier or punctuation text.
+func (hp *headerParser) is(text string) bool {
+	t := hp.peek()
+	return (t.kind == 'i' || t.kind == 'p') && t.text == text
+}
+
+func (hp *headerParser) accept(text string) bool {
+	if hp.is(text) {
+		hp.pos++
+		return true
+	}
+	return false
+}
+
+func (hp *headerParser) expect(text string) {
+	if !hp.accept(text) {
+		hp.fail("expected %s, found %q", text, hp.peek().text)
+	}
+}
+
+// skipBalanced skips a parenthesized, bracketed or braced group
+// starting at the next token.
+func (hp *headerParser) skipBalanced() {
+	depth := 0
+	for {
+		switch hp.next().text {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+		}
+		if depth <= 0 {
+			return
+		}
+	}
+}
+
+// Declarations.
+
+// A declSpec is the result of parsing declaration specifiers.
+type declSpec struct {
+	typ     dwarf.Type
+	typedef bool
+	attr    cAttr
+}
+
+// A cAttr collects the gcc attributes that affect layout.
+type cAttr struct {
+	packed  bool
+	aligned int64
+}
+
+func (a *cAttr) merge(b cAttr) {
+	a.packed = a.packed || b.packed
+	if b.aligned > a.aligned {
+		a.aligned = b.aligned
+	}
+}
+
+// declaration parses a single top-level declaration.
+func (hp *headerParser) declaration() {
+	if hp.accept(";") {
+		return
+	}
+	switch hp.peek().text {
+	case "_Static_assert", "static_assert", "asm", "__asm", "__asm__":
+		hp.fail("unsupported declaration")
+	}
+	spec := hp.declSpecs()
+	if hp.accept(";") {
+		return
+	}
+	for {
+		name, wrap, attr := hp.declarator()
+		if name == "" {
+			hp.fail("missing declarator name")
+		}
+		t := wrap(spec.typ)
+		attr.merge(spec.attr)
+		attr.merge(hp.attributes())
+		switch ft, isFunc := t.(*dwarf.FuncType); {
+		case spec.typedef:
+			if hp.typedefs[name] == nil {
+				td := &dwarf.TypedefType{Type: t}
+				td.Name = name
+				td.ByteSize = t.Size()
+				hp.typedefs[name] = td
+				if attr.aligned > 0 {
+					hp.align[td] = attr.aligned
+				}
+			}
+		case isFunc:
+			hp.funcs[name] = ft
+		default:
+			hp.vars[name] = t
+		}
+		if hp.is("{") {
+			// Function definition: skip the body.
+			hp.skipBalanced()
+			return
+		}
+		if hp.accept("=") {
+			hp.skipInitializer()
+		}
+		if hp.accept(",") {
+			continue
+		}
+		hp.expect(";")
+		return
+	}
+}
+
+// skipInitializer skips a variable initializer up to
+// the following comma or semicolon.
+func (hp *headerParser) skipInitializer() {
+	for !hp.is(",") && !hp.is(";") {
+		switch hp.peek().text {
+		case "(", "[", "{":
+			hp.skipBalanced()
+		default:
+			hp.next()
+		}
+	}
+}
+
+// basicWords counts the keywords that make up a basic type.
+type basicWords struct {
+	void, char, short, int, long, float, double, bool, signed, unsigned, complex, int128 int
+}
+
+func (w *basicWords) any() bool {
+	return *w != basicWords{}
+}
+
+// builtinTypes maps the compiler's predefined type names to the basic type they denote.
+var builtinTypes = map[string]string{
+	"__int128_t":  "__int128",
+	"__uint128_t": "__int128 unsigned",
+	"_Float32":    "float",
+	"_Float64":    "double",
+	"_Float32x":   "double",
+	"_Float64x":   "long double",
+	"_Float128":   "_Float128",
+	"__float128":  "_Float128",
+}
+
+// declSpecs parses declaration specifiers: storage classes,
+// qualifiers, attributes and the type specifier.
+func (hp *headerParser) declSpecs() declSpec {
+	var spec declSpec
+	var w basicWords
+	for {
+		tok := hp.peek()
+		if tok.kind != 'i' {
+			break
+		}
+		switch tok.text {
+		case "typedef":
+			spec.typedef = true
+		case "extern", "static", "inline", "__inline", "__inline__", "_Noreturn", "register", "auto",
+			"__thread", "_Thread_local", "__extension__",
+			"const", "volatile", "restrict", "__const", "__volatile", "__volatile__", "__restrict", "__restrict__",
+			"_Nonnull", "_Nullable", "_Null_unspecified", "__unaligned":
+		case "__attribute__", "__attribute", "__declspec", "_Alignas", "alignas":
+			spec.attr.merge(hp.attributes())
+			continue
+		case "_Atomic":
+			hp.next()
+			if hp.accept("(") {
+				spec.typ = hp.typeName()
+				hp.expect(")")
+			}
+			continue
+		case "void":
+			w.void++
+		case "char":
+			w.char++
+		case "short":
+			w.short++
+		case "int":
+			w.int++
+		case "long":
+			w.long++
+		case "float":
+			w.float++
+		case "double":
+			w.double++
+		case "_Bool":
+			w.bool++
+		case "signed", "__signed", "__signed__":
+			w.signed++
+		case "unsigned":
+			w.unsigned++
+		case "_Complex", "__complex__":
+			w.complex++
+		case "__int128":
+			w.int128++
+		case "struct", "union":
+			hp.next()
+			spec.typ = hp.structSpec(tok.text)
+			continue
+		case "enum":
+			hp.next()
+			spec.typ = hp.enumSpec()
+			continue
+		case "typeof", "__typeof", "__typeof__":
+			hp.next()
+			hp.expect("(")
+			if !hp.isTypeStart(hp.peek()) {
+				hp.fail("typeof of expression")
+			}
+			spec.typ = hp.typeName()
+			hp.expect(")")
+			continue
+		case "__builtin_va_list":
+			if spec.typ != nil || w.any() {
+				goto done
+			}
+			spec.typ = hp.vaList()
+		default:
+			// A typedef name is a type specifier only if
+			// we haven't seen one yet; otherwise it is the
+			// name being declared.
+			if spec.typ != nil || w.any() {
+				goto done
+			}
+			if b, ok := builtinTypes[tok.text]; ok {
+				spec.typ = hp.basic(b)
+			} else if td := hp.typedefs[tok.text]; td != nil {
+				spec.typ = td
+			} else {
+				goto done
+			}
+		}
+		hp.next()
+	}
+done:
+	if w.any() {
+		if spec.typ != nil {
+			hp.fail("conflicting type specifiers")
+		}
+		spec.typ = hp.basic(basicName(w))
+	}
+	if spec.typ == nil {
+		hp.fail("missing type specifier before %q", hp.peek().text)
+	}
+	return spec
+}
+
+// isTypeStart reports whether tok can begin a type name.
+func (hp *headerParser) isTypeStart(tok cToken) bool {
+	if tok.kind != 'i' {
+		return false
+	}
+	switch tok.text {
+	case "void", "char", "short", "int", "long", "float", "double", "_Bool",
+		"signed", "__signed", "__signed__", "unsigned", "_Complex", "__complex__", "__int128",
+		"struct", "union", "enum", "typeof", "__typeof", "__typeof__", "_Atomic", "__builtin_va_list",
+		"const", "volatile", "__const", "__volatile", "__volatile__", "__extension__":
+		return true
+	}
+	_, ok := builtinTypes[tok.text]
+	return ok || hp.typedefs[tok.text] != nil
+}
+
+// typeName parses a type name: specifiers and an abstract declarator.
+func (hp *headerParser) typeName() dwarf.Type {
+	spec := hp.declSpecs()
+	name, wrap, _ := hp.declarator()
+	if name != "" {
+		hp.fail("unexpected name %s in type", name)
+	}
+	return wrap(spec.typ)
+}
+
+// declarator parses a possibly abstract declarator.
+// It returns the declared name, if any, a function that
+// applies the declarator to the type given by the specifiers,
+// and any layout attributes attached to the declarator.
+func (hp *headerParser) declarator() (string, func(dwarf.Type) dwarf.Type, cAttr) {
+	var attr cAttr
+	nptr := 0
+	for {
+		if hp.accept("*") {
+			nptr++
+			continue
+		}
+		switch hp.peek().text {
+		case "const", "volatile", "restrict", "__const", "__volatile", "__volatile__", "__restrict", "__restrict__",
+			"_Nonnull", "_Nullable", "_Null_unspecified", "__extension__", "_Atomic":
+			hp.next()
+			continue
+		case "__attribute__", "__attribute":
+			attr.merge(hp.attributes())
+			continue
+		}
+		break
+	}
+
+	name := ""
+	inner := func(t dwarf.Type) dwarf.Type { return t }
+	switch tok := hp.peek(); {
+	case tok.kind == 'i' && !hp.isAttribute(tok.text):
+		name = hp.next().text
+	case tok.text == "(" && hp.nestedDeclarator():
+		hp.next()
+		var innerAttr cAttr
+		name, inner, innerAttr = hp.declarator()
+		attr.merge(innerAttr)
+		hp.expect(")")
+	}
+
+	// Suffixes apply before the pointers and, in reverse order,
+	// before one another: in a[2][3] the type of a[i] is int[3].
+	var suffixes []func(dwarf.Type) dwarf.Type
+	for {
+		attr.merge(hp.attributes())
+		if hp.accept("[") {
+			count := int64(-1)
+			for hp.accept("static") || hp.accept("const") || hp.accept("restrict") || hp.accept("__restrict") {
+			}
+			if !hp.is("]") {
+				count = hp.constExpr()
+			}
+			hp.expect("]")
+			suffixes = append(suffixes, func(t dwarf.Type) dwarf.Type { return hp.arrayOf(t, count) })
+			continue
+		}
+		if hp.accept("(") {
+			params := hp.params()
+			suffixes = append(suffixes, func(t dwarf.Type) dwarf.Type { return hp.funcOf(t, params) })
+			continue
+		}
+		break
+	}
+
+	return name, func(t dwarf.Type) dwarf.Type {
+		for i := 0; i < nptr; i++ {
+			t = hp.ptrTo(t)
+		}
+		for i := len(suffixes) - 1; i >= 0; i-- {
+			t = suffixes[i](t)
+		}
+		return inner(t)
+	}, attr
+}
+
+// nestedDeclarator reports whether the ( at the next token begins
+// a parenthesized declarator rather than a parameter list.
+func (hp *headerParser) nestedDeclarator() bool {
+	tok := hp.peekAt(1)
+	switch tok.text {
+	case "*", "(", "^":
+		return true
+	case "__attribute__", "__attribute":
+		return true
+	}
+	return tok.kind == 'i' && !hp.isTypeStart(tok)
+}
+
+func (hp *headerParser) isAttribute(s string) bool {
+	switch s {
+	case "__attribute__", "__attribute", "__asm__", "__asm", "asm", "__declspec", "_Alignas", "alignas":
+		return true
+	}
+	return false
+}
+
+// params parses a parameter list after its opening parenthesis.
+func (hp *headerParser) params() []dwarf.Type {
+	if hp.accept(")") {
+		// Unprototyped, which the compiler describes as
+		// a function with unspecified parameters.
+		return []dwarf.Type{&dwarf.DotDotDotType{}}
+	}
+	if hp.is("void") && hp.peekAt(1).text == ")" {
+		hp.next()
+		hp.next()
+		return []dwarf.Type{}
+	}
+	var params []dwarf.Type
+	for {
+		if hp.accept("...") {
+			params = append(params, &dwarf.DotDotDotType{})
+			hp.expect(")")
+			return params
+		}
+		spec := hp.declSpecs()
+		_, wrap, _ := hp.declarator()
+		hp.attributes()
+		// Array and function parameters are adjusted to pointers.
+		t := wrap(spec.typ)
+		switch bt := base(t).(type) {
+		case *dwarf.ArrayType:
+			t = hp.ptrTo(bt.Type)
+		case *dwarf.FuncType:
+			t = hp.ptrTo(bt)
+		}
+		params = append(params, t)
+		if !hp.accept(",") {
+			hp.expect(")")
+			return params
+		}
+	}
+}
+
+// attributes parses any sequence of gcc attributes, asm labels,
+// declspecs and alignment specifiers, returning the layout attributes.
+func (hp *headerParser) attributes() cAttr {
+	var attr cAttr
+	for {
+		switch hp.peek().text {
+		case "__attribute__", "__attribute":
+			hp.next()
+			hp.expect("(")
+			hp.expect("(")
+			for !hp.accept(")") {
+				if hp.accept(",") {
+					continue
+				}
+				name := strings.Trim(hp.next().text, "_")
+				switch {
+				case name == "packed":
+					attr.packed = true
+				case name == "aligned" && hp.is("("):
+					hp.next()
+					attr.merge(cAttr{aligned: hp.constExpr()})
+					hp.expect(")")
+				case name == "aligned":
+					attr.merge(cAttr{aligned: hp.p.Target.DefaultAlign})
+				case hp.is("("):
+					hp.skipBalanced()
+				}
+			}
+			hp.expect(")")
+		case "_Alignas", "alignas":
+			hp.next()
+			hp.expect("(")
+			if hp.isTypeStart(hp.peek()) {
+				attr.merge(cAttr{aligned: hp.alignOf(hp.typeName())})
+			} else {
+				attr.merge(cAttr{aligned: hp.constExpr()})
+			}
+			hp.expect(")")
+		case "__asm__", "__asm", "asm", "__declspec":
+			hp.next()
+			hp.skipBalanced()
+		default:
+			return attr
+		}
+	}
+}
+
+// A cField is a struct or union member before layout.
+type cField struct {
+	name  string
+	typ   dwarf.Type
+	width int64 // bit-field width, or -1
+	attr  cAttr
+}
+
+// structSpec parses a struct or union specifier after its keyword.
+func (hp *headerParser) structSpec(kind string) dwarf.Type {
+	attr := hp.attributes()
+	tag := ""
+	if hp.peek().kind == 'i' && !hp.isAttribute(hp.peek().text) {
+		tag = hp.next().text
+	}
+	attr.merge(hp.attributes())
+
+	var st *dwarf.StructType
+	if tag != "" {
+		st, _ = hp.tags[kind+" "+tag].(*dwarf.StructType)
+	}
+	if st == nil {
+		st = &dwarf.StructType{StructName: tag, Kind: kind, Incomplete: true}
+		st.ByteSize = -1
+		if tag != "" {
+			hp.tags[kind+" "+tag] = st
+		}
+	}
+	if !hp.accept("{") {
+		return st
+	}
+
+	var fields []cField
+	for !hp.accept("}") {
+		if hp.accept(";") {
+			continue
+		}
+		if hp.is("_Static_assert") || hp.is("static_assert") {
+			hp.next()
+			hp.skipBalanced()
+			hp.expect(";")
+			continue
+		}
+		spec := hp.declSpecs()
+		if hp.accept(";") {
+			// Anonymous struct or union member.
+			fields = append(fields, cField{typ: spec.typ, width: -1, attr: spec.attr})
+			continue
+		}
+		for {
+			f := cField{typ: spec.typ, width: -1, attr: spec.attr}
+			if !hp.is(":") {
+				var wrap func(dwarf.Type) dwarf.Type
+				var attr cAttr
+				f.name, wrap, attr = hp.declarator()
+				f.typ = wrap(spec.typ)
+				f.attr.merge(attr)
+			}
+			if hp.accept(":") {
+				f.width = hp.constExpr()
+			}
+			f.attr.merge(hp.attributes())
+			fields = append(fields, f)
+			if !hp.accept(",") {
+				hp.expect(";")
+				break
+			}
+		}
+	}
+	attr.merge(hp.attributes())
+	hp.layout(st, fields, attr)
+	return st
+}
+
+// layout assigns offsets to fields following the System V
+// rules that gcc uses, and completes st.
+func (hp *headerParser) layout(st *dwarf.StructType, fields []cField, attr cAttr) {
+	union := st.Kind == "union"
+	st.Field = nil
+	var bits, size int64
+	align := int64(1)
+	for _, f := range fields {
+		if union {
+			bits = 0
+		}
+		fsize := f.typ.Size()
+		if fsize < 0 {
+			hp.fail("field %s has incomplete type", f.name)
+		}
+		natural := hp.alignOf(f.typ)
+		falign := natural
+		if attr.packed || f.attr.packed {
+			falign = 1
+		}
+		if f.attr.aligned > falign {
+			falign = f.attr.aligned
+		}
+
+		sf := &dwarf.StructField{Name: f.name, Type: f.typ}
+		if f.width >= 0 {
+			unit := fsize * 8
+			if f.width > unit {
+				hp.fail("bit-field %s too wide", f.name)
+			}
+			if f.width == 0 {
+				// A zero-width bit-field aligns the next field
+				// to the next unit of its type.
+				bits = roundUp(bits, natural*8)
+				continue
+			}
+			start := bits
+			if falign > 1 && start%(natural*8)+f.width > unit {
+				start = roundUp(start, natural*8)
+			}
+			unitOff := start / 8 / natural * natural
+			if start+f.width > unitOff*8+unit {
+				unitOff = start / 8
+			}
+			sf.ByteOffset = unitOff
+			sf.ByteSize = fsize
+			sf.BitSize = f.width
+			sf.DataBitOffset = start
+			if hp.p.Target.BigEndian {
+				sf.BitOffset = start - unitOff*8
+			} else {
+				sf.BitOffset = unit - (start - unitOff*8) - f.width
+			}
+			bits = start + f.width
+			if f.name == "" {
+				// Unnamed bit-fields are only padding.
+				if e := (bits + 7) / 8; e > size {
+					size = e
+				}
+				continue
+			}
+		} else {
+			start := roundUp(bits, falign*8)
+			sf.ByteOffset = start / 8
+			bits = start + fsize*8
+		}
+		if falign > align {
+			align = falign
+		}
+		if e := (bits + 7) / 8; e > size {
+			size = e
+		}
+		st.Field = append(st.Field, sf)
+	}
+	if attr.aligned > align {
+		align = attr.aligned
+	}
+	st.ByteSize = roundUp(size, align)
+	st.Incomplete = false
+	hp.align[st] = align
+}
+
+func roundUp(x, align int64) int64 {
+	if align <= 1 {
+		return x
+	}
+	return (x + align - 1) / align * align
+}
+
+// enumSpec parses an enum specifier after its keyword.
+func (hp *headerParser) enumSpec() dwarf.Type {
+	hp.attributes()
+	tag := ""
+	if hp.peek().kind == 'i' && !hp.isAttribute(hp.peek().text) {
+		tag = hp.next().text
+	}
+	hp.attributes()
+	if hp.accept(":") {
+		// C23 fixed underlying type; the size comes from the values anyway.
+		hp.typeName()
+	}
+
+	var et *dwarf.EnumType
+	if tag != "" {
+		et, _ = hp.tags["enum "+tag].(*dwarf.EnumType)
+	}
+	if et == nil {
+		et = &dwarf.EnumType{EnumName: tag}
+		et.ByteSize = 4
+		if tag != "" {
+			hp.tags["enum "+tag] = et
+		}
+	}
+	if !hp.accept("{") {
+		return et
+	}
+
+	var next int64
+	et.Val = []*dwarf.EnumValue{}
+	for !hp.accept("}") {
+		tok := hp.next()
+		if tok.kind != 'i' {
+			hp.fail("expected enumerator, found %q", tok.text)
+		}
+		hp.attributes()
+		if hp.accept("=") {
+			next = hp.constExpr()
+		}
+		hp.enumerators[tok.text] = next
+		et.Val = append(et.Val, &dwarf.EnumValue{Name: tok.text, Val: next})
+		next++
+		if !hp.accept(",") {
+			hp.expect("}")
+			break
+		}
+	}
+
+	attr := hp.attributes()
+	min, max := int64(0), int64(0)
+	for _, v := range et.Val {
+		if v.Val < min {
+			min = v.Val
+		}
+		if v.Val > max {
+			max = v.Val
+		}
+	}
+	et.ByteSize = 4
+	if min < math.MinInt32 || max > math.MaxUint32 {
+		et.ByteSize = 8
+	} else if attr.packed {
+		switch {
+		case min >= math.MinInt8 && max <= math.MaxUint8 && (min >= 0 || max <= math.MaxInt8):
+			et.ByteSize = 1
+		case min >= math.MinInt16 && max <= math.MaxUint16 && (min >= 0 || max <= math.MaxInt16):
+			et.ByteSize = 2
+		}
+	}
+	return et
+}
+
+// Types.
+
+// basicName returns the name gcc uses in its debug
+// information for the basic type spelled by w.
+func basicName(w basicWords) string {
+	switch {
+	case w.void > 0:
+		return "void"
+	case w.bool > 0:
+		return "_Bool"
+	case w.float > 0:
+		if w.complex > 0 {
+			return "complex float"
+		}
+		return "float"
+	case w.double > 0:
+		if w.long > 0 {
+			if w.complex > 0 {
+				return "complex long double"
+			}
+			return "long double"
+		}
+		if w.complex > 0 {
+			return "complex double"
+		}
+		return "double"
+	case w.char > 0:
+		if w.unsigned > 0 {
+			return "unsigned char"
+		}
+		if w.signed > 0 {
+			return "signed char"
+		}
+		return "char"
+	}
+	u := ""
+	if w.unsigned > 0 {
+		u = "unsigned "
+	}
+	switch {
+	case w.int128 > 0:
+		if u != "" {
+			return "__int128 unsigned"
+		}
+		return "__int128"
+	case w.short > 0:
+		return "short " + u + "int"
+	case w.long >= 2:
+		return "long long " + u + "int"
+	case w.long == 1:
+		return "long " + u + "int"
+	}
+	return u + "int"
+}
+
+// basic returns the basic type with the given gcc name.
+func (hp *headerParser) basic(name string) dwarf.Type {
+	if t, ok := hp.basics[name]; ok {
+		return t
+	}
+	long := hp.p.PtrSize
+	if goos == "windows" {
+		long = 4
+	}
+	longDouble := hp.p.Target.LongDoubleSize
+
+	var t dwarf.Type
+	switch name {
+	case "void":
+		t = &dwarf.VoidType{}
+	case "_Bool":
+		t = &dwarf.BoolType{}
+	case "char":
+		if hp.p.Target.UnsignedChar {
+			t = &dwarf.UcharType{}
+		} else {
+			t = &dwarf.CharType{}
+		}
+	case "signed char":
+		t = &dwarf.CharType{}
+	case "unsigned char":
+		t = &dwarf.UcharType{}
+	case "short int", "int", "long int", "long long int", "__int128":
+		t = &dwarf.IntType{}
+	case "short unsigned int", "unsigned int", "long unsigned int", "long long unsigned int", "__int128 unsigned":
+		t = &dwarf.UintType{}
+	case "float", "double", "long double", "_Float128":
+		t = &dwarf.FloatType{}
+	case "complex float", "complex double", "complex long double":
+		t = &dwarf.ComplexType{}
+	default:
+		hp.fail("unknown basic type %s", name)
+	}
+
+	c := t.Common()
+	c.Name = name
+	switch name {
+	case "void":
+		c.ByteSize = 0
+		c.Name = ""
+	case "_Bool", "char", "signed char", "unsigned char":
+		c.ByteSize = 1
+	case "short int", "short unsigned int":
+		c.ByteSize = 2
+	case "int", "unsigned int", "float":
+		c.ByteSize = 4
+	case "long int", "long unsigned int":
+		c.ByteSize = long
+	case "long long int", "long long unsigned int", "double", "complex float":
+		c.ByteSize = 8
+	case "__int128", "__int128 unsigned", "_Float128", "complex double":
+		c.ByteSize = 16
+	case "long double":
+		c.ByteSize = longDouble
+	case "complex long double":
+		c.ByteSize = 2 * longDouble
+	}
+	hp.basics[name] = t
+	return t
+}
+
+// vaList returns the type of __builtin_va_list.
+func (hp *headerParser) vaList() dwarf.Type {
+	if t, ok := hp.tags["builtin va_list"]; ok {
+		return t
+	}
+	var t dwarf.Type
+	switch goarch {
+	case "amd64":
+		// struct __va_list_tag { unsigned gp_offset, fp_offset; void *overflow_arg_area, *reg_save_area; }[1]
+		st := &dwarf.StructType{StructName: "__va_list_tag", Kind: "struct"}
+		u, v := hp.basic("unsigned int"), hp.ptrTo(hp.basic("void"))
+		hp.layout(st, []cField{{"gp_offset", u, -1, cAttr{}}, {"fp_offset", u, -1, cAttr{}},
+			{"overflow_arg_area", v, -1, cAttr{}}, {"reg_save_area", v, -1, cAttr{}}}, cAttr{})
+		t = hp.arrayOf(st, 1)
+	case "arm64":
+		// struct __va_list { void *__stack, *__gr_top, *__vr_top; int __gr_offs, __vr_offs; }
+		st := &dwarf.StructType{StructName: "__va_list", Kind: "struct"}
+		i, v := hp.basic("int"), hp.ptrTo(hp.basic("void"))
+		hp.layout(st, []cField{{"__stack", v, -1, cAttr{}}, {"__gr_top", v, -1, cAttr{}}, {"__vr_top", v, -1, cAttr{}},
+			{"__gr_offs", i, -1, cAttr{}}, {"__vr_offs", i, -1, cAttr{}}}, cAttr{})
+		t = st
+	default:
+		t = hp.ptrTo(hp.basic("char"))
+	}
+	td := &dwarf.TypedefType{Type: t}
+	td.Name = "__builtin_va_list"
+	td.ByteSize = t.Size()
+	hp.tags["builtin va_list"] = td
+	return td
+}
+
+// ptrTo returns the pointer type to t.  Like the compiler's debug
+// information, it has a single pointer type for each element type,
+// which typeConv relies on when completing pointer types.
+func (hp *headerParser) ptrTo(t dwarf.Type) dwarf.Type {
+	if pt, ok := hp.ptrs[t]; ok {
+		return pt
+	}
+	pt := &dwarf.PtrType{Type: t}
+	pt.ByteSize = hp.p.PtrSize
+	hp.ptrs[t] = pt
+	return pt
+}
+
+func (hp *headerParser) arrayOf(t dwarf.Type, count int64) dwarf.Type {
+	at := &dwarf.ArrayType{Type: t, Count: count}
+	at.ByteSize = at.Size()
+	return at
+}
+
+func (hp *headerParser) funcOf(ret dwarf.Type, params []dwarf.Type) dwarf.Type {
+	ft := &dwarf.FuncType{ReturnType: ret, ParamType: params}
+	ft.ByteSize = -1
+	return ft
+}
+
+// alignOf returns the alignment of t.
+func (hp *headerParser) alignOf(t dwarf.Type) int64 {
+	if a, ok := hp.align[t]; ok {
+		return a
+	}
+	switch t := t.(type) {
+	case *dwarf.TypedefType:
+		return hp.alignOf(t.Type)
+	case *dwarf.QualType:
+		return hp.alignOf(t.Type)
+	case *dwarf.ArrayType:
+		return hp.alignOf(t.Type)
+	case *dwarf.StructType, *dwarf.VoidType:
+		return 1
+	case *dwarf.ComplexType:
+		return hp.scalarAlign(t.ByteSize / 2)
+	}
+	return hp.scalarAlign(t.Size())
+}
+
+// scalarAlign returns the alignment of a scalar of the given size.
+func (hp *headerParser) scalarAlign(size int64) int64 {
+	max := hp.p.Target.MaxAlign
+	a := int64(1)
+	for a < size && a < max {
+		a *= 2
+	}
+	return a
+}
+
+// isUnsigned reports whether values of t are unsigned.
+func isUnsigned(t dwarf.Type) bool {
+	switch base(t).(type) {
+	case *dwarf.UintType, *dwarf.UcharType, *dwarf.BoolType, *dwarf.PtrType:
+		return true
+	}
+	return false
+}
+
+// Constant expressions.
+
+// A cValue is the value of a C constant expression.
+type cValue struct {
+	i        int64
+	f        float64
+	isFloat  bool
+	unsigned bool
+}
+
+func boolValue(b bool) cValue {
+	if b {
+		return cValue{i: 1}
+	}
+	return cValue{}
+}
+
+// float returns v as a floating-point number.
+func (v cValue) float() float64 {
+	switch {
+	case v.isFloat:
+		return v.f
+	case v.unsigned:
+		return float64(uint64(v.i))
+	}
+	return float64(v.i)
+}
+
+func (v cValue) isZero() bool {
+	if v.isFloat {
+		return v.f == 0
+	}
+	return v.i == 0
+}
+
+// String returns the Go spelling of v, as used for Name.Const.
+// Integers are written in hex, for consistency with enum-derived constants.
+func (v cValue) String() string {
+	switch {
+	case v.isFloat:
+		s := strconv.FormatFloat(v.f, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s
+	case v.unsigned:
+		return fmt.Sprintf("%#x", uint64(v.i))
+	}
+	return fmt.Sprintf("%#x", v.i)
+}
+
+var cBinaryPrec = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"|":  3,
+	"^":  4,
+	"&":  5,
+	"==": 6, "!=": 6,
+	"<": 7, ">": 7, "<=": 7, ">=": 7,
+	"<<": 8, ">>": 8,
+	"+": 9, "-": 9,
+	"*": 10, "/": 10, "%": 10,
+}
+
+// constExpr parses and evaluates an integer constant expression.
+func (hp *headerParser) constExpr() int64 {
+	v := hp.expr()
+	if v.isFloat {
+		hp.fail("floating-point constant where integer is required")
+	}
+	return v.i
+}
+
+// expr parses and evaluates an arithmetic constant expression.
+func (hp *headerParser) expr() cValue {
+	c := hp.binaryExpr(1)
+	if !hp.accept("?") {
+		return c
+	}
+	x := hp.expr()
+	hp.expect(":")
+	y := hp.expr()
+	if !c.isZero() {
+		return x
+	}
+	return y
+}
+
+func (hp *headerParser) binaryExpr(prec int) cValue {
+	x := hp.unaryExpr()
+	for {
+		tok := hp.peek()
+		p, ok := cBinaryPrec[tok.text]
+		if tok.kind != 'p' || !ok || p < prec {
+			return x
+		}
+		hp.next()
+		y := hp.binaryExpr(p + 1)
+		x = hp.binaryOp(tok.text, x, y)
+	}
+}
+
+// binaryOp applies op to x and y following the usual arithmetic
+// conversions: if either operand is floating-point, so is the
+// operation, and otherwise it is unsigned if either operand is.
+func (hp *headerParser) binaryOp(op string, x, y cValue) cValue {
+	switch op {
+	case "||":
+		return boolValue(!x.isZero() || !y.isZero())
+	case "&&":
+		return boolValue(!x.isZero() && !y.isZero())
+	}
+
+	if x.isFloat || y.isFloat {
+		a, b := x.float(), y.float()
+		switch op {
+		case "==":
+			return boolValue(a == b)
+		case "!=":
+			return boolValue(a != b)
+		case "<":
+			return boolValue(a < b)
+		case ">":
+			return boolValue(a > b)
+		case "<=":
+			return boolValue(a <= b)
+		case ">=":
+			return boolValue(a >= b)
+		case "+":
+			return cValue{f: a + b, isFloat: true}
+		case "-":
+			return cValue{f: a - b, isFloat: true}
+		case "*":
+			return cValue{f: a * b, isFloat: true}
+		case "/":
+			if b == 0 {
+				hp.fail("division by zero")
+			}
+			return cValue{f: a / b, isFloat: true}
+		}
+		hp.fail("invalid operator %s on floating-point constant", op)
+	}
+
+	a, b := x.i, y.i
+	unsigned := x.unsigned || y.unsigned
+	switch op {
+	case "|":
+		return cValue{i: a | b, unsigned: unsigned}
+	case "^":
+		return cValue{i: a ^ b, unsigned: unsigned}
+	case "&":
+		return cValue{i: a & b, unsigned: unsigned}
+	case "+":
+		return cValue{i: a + b, unsigned: unsigned}
+	case "-":
+		return cValue{i: a - b, unsigned: unsigned}
+	case "*":
+		return cValue{i: a * b, unsigned: unsigned}
+	case "==":
+		return boolValue(a == b)
+	case "!=":
+		return boolValue(a != b)
+	case "<", ">", "<=", ">=":
+		cmp := 0
+		switch {
+		case unsigned && uint64(a) < uint64(b), !unsigned && a < b:
+			cmp = -1
+		case a != b:
+			cmp = 1
+		}
+		switch op {
+		case "<":
+			return boolValue(cmp < 0)
+		case ">":
+			return boolValue(cmp > 0)
+		case "<=":
+			return boolValue(cmp <= 0)
+		}
+		return boolValue(cmp >= 0)
+	case "<<":
+		// The result has the type of the left operand.
+		return cValue{i: a << uint64(b), unsigned: x.unsigned}
+	case ">>":
+		if x.unsigned {
+			return cValue{i: int64(uint64(a) >> uint64(b)), unsigned: true}
+		}
+		return cValue{i: a >> uint64(b)}
+	case "/", "%":
+		if b == 0 {
+			hp.fail("division by zero")
+		}
+		switch {
+		case unsigned && op == "/":
+			return cValue{i: int64(uint64(a) / uint64(b)), unsigned: true}
+		case unsigned:
+			return cValue{i: int64(uint64(a) % uint64(b)), unsigned: true}
+		case op == "/":
+			return cValue{i: a / b}
+		}
+		return cValue{i: a % b}
+	}
+	hp.fail("unknown operator %s", op)
+	panic("not reached")
+}
+
+func (hp *headerParser) unaryExpr() cValue {
+	tok := hp.next()
+	switch tok.kind {
+	case 'n':
+		return hp.numLit(tok.text)
+	case 'c':
+		return cValue{i: hp.charLit(tok.text)}
+	case 'i':
+		switch tok.text {
+		case "sizeof", "_Alignof", "__alignof__", "__alignof", "alignof":
+			var t dwarf.Type
+			if hp.is("(") && hp.isTypeStart(hp.peekAt(1)) {
+				hp.next()
+				t = hp.typeName()
+				hp.expect(")")
+			} else {
+				hp.fail("%s of expression", tok.text)
+			}
+			if tok.text == "sizeof" {
+				if t.Size() < 0 {
+					hp.fail("sizeof of incomplete type")
+				}
+				return cValue{i: t.Size(), unsigned: true}
+			}
+			return cValue{i: hp.alignOf(t), unsigned: true}
+		case "__extension__":
+			return hp.unaryExpr()
+		}
+		if v, ok := hp.enumerators[tok.text]; ok {
+			return cValue{i: v}
+		}
+		if body, ok := hp.defines[tok.text]; ok && !hp.expanding[tok.text] {
+			return hp.expandDefine(tok.text, body)
+		}
+		hp.fail("%s is not a constant", tok.text)
+	case 'p':
+		switch tok.text {
+		case "-":
+			v := hp.unaryExpr()
+			if v.isFloat {
+				v.f = -v.f
+			} else {
+				v.i = -v.i
+			}
+			return v
+		case "+":
+			return hp.unaryExpr()
+		case "~":
+			v := hp.unaryExpr()
+			if v.isFloat {
+				hp.fail("invalid operator ~ on floating-point constant")
+			}
+			v.i = ^v.i
+			return v
+		case "!":
+			return boolValue(hp.unaryExpr().isZero())
+		case "(":
+			if hp.isTypeStart(hp.peek()) {
+				t := hp.typeName()
+				hp.expect(")")
+				return hp.convert(hp.unaryExpr(), t)
+			}
+			x := hp.expr()
+			hp.expect(")")
+			return x
+		}
+	}
+	hp.fail("unexpected %q in constant expression", tok.text)
+	panic("not reached")
+}
+
+// expandDefine evaluates the body of the object-like macro name.
+func (hp *headerParser) expandDefine(name, body string) cValue {
+	hp.expanding[name] = true
+	defer delete(hp.expanding, name)
+	v, ok := hp.evalConst(body)
+	if !ok {
+		hp.fail("%s is not a constant", name)
+	}
+	return v
+}
+
+// convert converts v to the arithmetic type t, as a C cast would.
+func (hp *headerParser) convert(v cValue, t dwarf.Type) cValue {
+	switch base(t).(type) {
+	case *dwarf.BoolType:
+		return boolValue(!v.isZero())
+	case *dwarf.FloatType:
+		f := v.float()
+		if t.Size() == 4 {
+			f = float64(float32(f))
+		}
+		return cValue{f: f, isFloat: true}
+	case *dwarf.PtrType, *dwarf.StructType, *dwarf.ArrayType, *dwarf.FuncType, *dwarf.VoidType, *dwarf.ComplexType:
+		hp.fail("cast to non-arithmetic type %s", t)
+	}
+	unsigned := isUnsigned(t)
+	i := v.i
+	if v.isFloat {
+		if unsigned {
+			i = int64(uint64(v.f))
+		} else {
+			i = int64(v.f)
+		}
+	}
+	if size := t.Size(); size > 0 && size < 8 {
+		shift := uint(64 - 8*size)
+		if unsigned {
+			i = int64(uint64(i) << shift >> shift)
+		} else {
+			i = i << shift >> shift
+		}
+	}
+	return cValue{i: i, unsigned: unsigned}
+}
+
+// numLit returns the value of the C integer or floating-point literal s.
+func (hp *headerParser) numLit(s string) cValue {
+	lower := strings.ToLower(s)
+	hex := strings.HasPrefix(lower, "0x")
+	if strings.Contains(lower, ".") || !hex && strings.Contains(lower, "e") || hex && strings.Contains(lower, "p") {
+		f, err := strconv.ParseFloat(strings.TrimRight(lower, "fl"), 64)
+		if err != nil {
+			hp.fail("bad floating-point constant %s", s)
+		}
+		if strings.HasSuffix(lower, "f") {
+			f = float64(float32(f))
+		}
+		return cValue{f: f, isFloat: true}
+	}
+	digits := strings.TrimRight(lower, "ul")
+	v, err := strconv.ParseUint(digits, 0, 64)
+	if err != nil {
+		hp.fail("bad integer constant %s", s)
+	}
+	unsigned := strings.Contains(lower[len(digits):], "u") || v > math.MaxInt64
+	return cValue{i: int64(v), unsigned: unsigned}
+}
+
+// charLit returns the value of the C character literal s.
+func (hp *headerParser) charLit(s string) int64 {
+	s = s[strings.IndexByte(s, '\''):]
+	if len(s) < 3 || s[len(s)-1] != '\'' {
+		hp.fail("bad character constant %s", s)
+	}
+	body := s[1 : len(s)-1]
+	if strings.HasPrefix(body, "\\") && len(body) > 1 && '0' <= body[1] && body[1] <= '7' {
+		v, err := strconv.ParseUint(body[1:], 8, 32)
+		if err != nil {
+			hp.fail("bad character constant %s", s)
+		}
+		return int64(int8(v))
+	}
+	r, _, tail, err := strconv.UnquoteChar(body, '\'')
+	if err != nil || tail != "" {
+		hp.fail("bad character constant %s", s)
+	}
+	if strings.HasPrefix(body, "\\x") {
+		return int64(int8(r))
+	}
+	return int64(r)
+}
@@ -0,0 +1,172 @@
+package cgo
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// cparseSource refers to C types whose layouts the header parser
+// must work out as gcc does: padding, bit-fields, packed structs,
+// anonymous unions, arrays, function pointers and enums.
+const cparseSource = `package x
+
+/*
+#include <stdint.h>
+#include <stddef.h>
+
+typedef struct {
+	char c;
+	double d;
+	short s;
+} padded;
+
+struct flags {
+	unsigned int a : 3;
+	unsigned int b : 7;
+	int c : 12;
+	unsigned char d;
+	unsigned long long e : 40;
+};
+
+struct __attribute__((packed)) wire {
+	uint8_t kind;
+	uint32_t length;
+	uint16_t check;
+};
+
+typedef struct {
+	int tag;
+	union {
+		int i;
+		float f;
+		char bytes[6];
+	};
+	struct {
+		short x, y;
+	} point;
+} variant;
+
+typedef int (*callback)(void *data, size_t n);
+
+struct node {
+	struct node *next;
+	callback cb;
+	long values[3];
+	const char *name;
+};
+
+enum color { RED, GREEN = 5, BLUE };
+typedef enum { SMALL = -1, LARGE = 1 << 20 } size_class;
+
+#define LIMIT 42
+#define SCALE (LIMIT * 2 + 1)
+*/
+import "C"
+
+var (
+	_ C.padded
+	_ C.struct_flags
+	_ C.struct_wire
+	_ C.variant
+	_ C.callback
+	_ C.struct_node
+	_ C.enum_color
+	_ C.size_class
+	_ = C.LIMIT
+	_ = C.SCALE
+	_ = C.GREEN
+	_ = C.LARGE
+)
+`
+
+// TestParseHeadersLayout checks that the header parser lays out the
+// C types of cparseSource exactly as the DWARF from gcc does.
+func TestParseHeadersLayout(t *testing.T) {
+	needCC(t)
+	dwarf := cparseLayouts(t, false)
+	parsed := cparseLayouts(t, true)
+	if dwarf != parsed {
+		t.Errorf("header parser layouts differ from DWARF\n--- DWARF\n%s--- parsed\n%s", dwarf, parsed)
+	}
+}
+
+// needCC skips the test unless there is a C compiler,
+// falling back to gcc when $CC and defaultCC are missing.
+func needCC(t *testing.T) {
+	cc := os.Getenv("CC")
+	if cc == "" {
+		cc = defaultCC
+		if _, err := exec.LookPath(cc); err != nil {
+			cc = "gcc"
+			t.Setenv("CC", cc)
+		}
+	}
+	if _, err := exec.LookPath(strings.Fields(cc)[0]); err != nil {
+		t.Skipf("C compiler %s not found", cc)
+	}
+}
+
+// cparseLayouts translates cparseSource, by parsing the headers or
+// from DWARF, and describes the names and C types found.
+func cparseLayouts(t *testing.T, parse bool) string {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "x.go")
+	if err := os.WriteFile(file, []byte(cparseSource), 0666); err != nil {
+		t.Fatal(err)
+	}
+	p := NewPackage(nil, Options{ObjDir: dir, Godefs: true, Reproducible: true, TrimPath: dir})
+	p.ParseHeaders = parse
+	f := p.ReadFile(file)
+	p.ParseFlags(f, file)
+	p.Translate(f)
+
+	var b strings.Builder
+	for _, key := range nameKeys(f.Name) {
+		n := f.Name[key]
+		fmt.Fprintf(&b, "C.%s: %s", key, n.Kind)
+		if n.Type != nil {
+			fmt.Fprintf(&b, " %s size %d align %d", n.Type.C, n.Type.Size, n.Type.Align)
+		}
+		if n.Const != "" {
+			fmt.Fprintf(&b, " = %s", n.Const)
+		}
+		b.WriteString("\n")
+	}
+	for _, name := range TypedefNames() {
+		describeLayout(&b, name, LookupTypedef(name), "")
+	}
+	for _, e := range Enums() {
+		fmt.Fprintf(&b, "%s:", e.Name)
+		for _, ev := range e.Enumerators {
+			fmt.Fprintf(&b, " %s=%d", ev.Name, ev.Value)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func describeLayout(b *strings.Builder, name string, t *Type, indent string) {
+	fmt.Fprintf(b, "%s%s: %s size %d align %d", indent, name, t.C, t.Size, t.Align)
+	if t.Union {
+		b.WriteString(" union")
+	}
+	if t.Packed {
+		b.WriteString(" packed")
+	}
+	b.WriteString("\n")
+	for _, f := range t.Fields {
+		if f.Name == "" && f.Type.Fields != nil {
+			describeLayout(b, fmt.Sprintf("(anonymous at %d)", f.Offset), f.Type, indent+"\t")
+			continue
+		}
+		fmt.Fprintf(b, "%s\t%s at %d: %s", indent, f.Name, f.Offset, f.Type.C)
+		if f.BitSize > 0 {
+			fmt.Fprintf(b, " bits %d:%d", f.BitOffset, f.BitSize)
+		}
+		b.WriteString("\n")
+	}
+}
@@ -104,6 +104,11 @@ the CC environment variable any time you run the go tool.  The
 CXX_FOR_TARGET and CXX environment variables work in a similar way for
 C++ code.
 
+A Package translating for a target other than the host, as set by
+Options.GOOS and Options.GOARCH, first tries the compiler named by
+$CC_FOR_goos_goarch, such as CC_FOR_linux_arm64.  If the compiler is
+clang it is given a -target flag for the target.
+
 Go references to C
 
 Within the Go file, C's struct field names that are keywords in Go
@@ -212,6 +217,9 @@ They will be available in the C code as:
 found in the _cgo_export.h generated header, after any preambles
 copied from the cgo input files. Functions with multiple
 return values are mapped to functions returning a struct.
+Go struct types, including fixed-size arrays within them, are
+mapped to C structs with the same layout, defined in the header
+under the name of the Go type, or _GoStructN for a struct literal.
 Not all Go types can be mapped to C types in a useful way.
 
 Using //export in a file places a restriction on the preamble:
@@ -0,0 +1,105 @@
+// Dynamic import data of executables and shared objects.
+
+package cgo
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DynImportInfo describes what an executable or shared object
+// needs from shared libraries at run time.
+type DynImportInfo struct {
+	Format    string       // "elf", "macho" or "pe"
+	Linker    string       // dynamic linker (ELF only), or ""
+	Libraries []string     // shared libraries needed
+	Symbols   []*DynImport // symbols imported from them
+}
+
+// A DynImport is a symbol imported from a shared library.
+type DynImport struct {
+	Name    string // symbol name, as used by Go
+	Target  string // name to import; for ELF, including any #version
+	Version string // ELF symbol version, or ""
+	Library string // library providing the symbol, or "" if not recorded
+}
+
+// DynamicImports reads the ELF, Mach-O or PE file path and returns
+// its dynamic imports.
+func DynamicImports(path string) (*DynImportInfo, error) {
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		info := &DynImportInfo{Format: "elf"}
+		if sec := f.Section(".interp"); sec != nil {
+			if data, err := sec.Data(); err == nil && len(data) > 1 {
+				// skip trailing \0 in data
+				info.Linker = string(data[:len(data)-1])
+			}
+		}
+		sym, err := f.ImportedSymbols()
+		if err != nil {
+			return nil, fmt.Errorf("cannot load imported symbols from ELF file %s: %v", path, err)
+		}
+		for _, s := range sym {
+			targ := s.Name
+			if s.Version != "" {
+				targ += "#" + s.Version
+			}
+			info.Symbols = append(info.Symbols, &DynImport{Name: s.Name, Target: targ, Version: s.Version, Library: s.Library})
+		}
+		info.Libraries, err = f.ImportedLibraries()
+		if err != nil {
+			return nil, fmt.Errorf("cannot load imported libraries from ELF file %s: %v", path, err)
+		}
+		return info, nil
+	}
+
+	if f, err := macho.Open(path); err == nil {
+		defer f.Close()
+		info := &DynImportInfo{Format: "macho"}
+		sym, err := f.ImportedSymbols()
+		if err != nil {
+			return nil, fmt.Errorf("cannot load imported symbols from Mach-O file %s: %v", path, err)
+		}
+		for _, s := range sym {
+			if len(s) > 0 && s[0] == '_' {
+				s = s[1:]
+			}
+			info.Symbols = append(info.Symbols, &DynImport{Name: s, Target: s})
+		}
+		info.Libraries, err = f.ImportedLibraries()
+		if err != nil {
+			return nil, fmt.Errorf("cannot load imported libraries from Mach-O file %s: %v", path, err)
+		}
+		return info, nil
+	}
+
+	if f, err := pe.Open(path); err == nil {
+		defer f.Close()
+		info := &DynImportInfo{Format: "pe"}
+		sym, err := f.ImportedSymbols()
+		if err != nil {
+			return nil, fmt.Errorf("cannot load imported symbols from PE file %s: %v", path, err)
+		}
+		// PE records the library of each symbol, not a separate list.
+		libs := make(map[string]bool)
+		for _, s := range sym {
+			ss := strings.Split(s, ":")
+			name := strings.Split(ss[0], "@")[0]
+			lib := strings.ToLower(ss[1])
+			info.Symbols = append(info.Symbols, &DynImport{Name: name, Target: ss[0], Library: lib})
+			if !libs[lib] {
+				libs[lib] = true
+				info.Libraries = append(info.Libraries, lib)
+			}
+		}
+		sort.Strings(info.Libraries)
+		return info, nil
+	}
+
+	return nil, fmt.Errorf("cannot parse %s as ELF, Mach-O or PE", path)
+}
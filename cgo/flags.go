@@ -0,0 +1,134 @@
+// Flags for #cgo directives: build constraints and pkg-config.
+
+package cgo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/build"
+	"os"
+	"strings"
+)
+
+// A FlagProvider supplies the compiler and linker flags for the
+// packages named in #cgo pkg-config directives.
+type FlagProvider interface {
+	Flags(packages []string) (cflags, ldflags []string, err error)
+}
+
+// PkgConfig is a FlagProvider that runs a program taking the
+// arguments of pkg-config: Program --cflags packages, and
+// Program --libs packages.  If Program is "", it is $PKG_CONFIG,
+// or pkg-config if that is not set.
+type PkgConfig struct {
+	Program string
+}
+
+func (pc PkgConfig) Flags(packages []string) (cflags, ldflags []string, err error) {
+	for _, name := range packages {
+		if len(name) == 0 || name[0] == '-' {
+			return nil, nil, fmt.Errorf("invalid name: %q", name)
+		}
+	}
+	prog := strings.Fields(pc.Program)
+	if len(prog) == 0 {
+		prog = strings.Fields(os.Getenv("PKG_CONFIG"))
+	}
+	if len(prog) == 0 {
+		prog = []string{"pkg-config"}
+	}
+	if cflags, err = pc.run(prog, "--cflags", packages); err != nil {
+		return nil, nil, err
+	}
+	if ldflags, err = pc.run(prog, "--libs", packages); err != nil {
+		return nil, nil, err
+	}
+	return cflags, ldflags, nil
+}
+
+func (pc PkgConfig) run(prog []string, flag string, packages []string) ([]string, error) {
+	args := append(append(prog[:len(prog):len(prog)], flag), packages...)
+	stdout, stderr, ok := run(nil, args)
+	if !ok {
+		os.Stderr.Write(stderr)
+		return nil, errors.New(prog[0] + " failed")
+	}
+	return splitQuoted(string(stdout))
+}
+
+// matchCgoConds reports whether any of the build constraints conds
+// of a #cgo directive holds for the target.
+func matchCgoConds(conds []string) bool {
+	for _, cond := range conds {
+		if matchCgoCond(cond) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchCgoCond reports whether the build constraint cond, such as
+// "linux,amd64" or "!windows", holds for the target.  As in go/build,
+// the comma-separated terms must all hold.
+func matchCgoCond(cond string) bool {
+	for _, term := range strings.Split(cond, ",") {
+		if !matchCgoTag(term) {
+			return false
+		}
+	}
+	return true
+}
+
+// unixOS lists the operating systems that satisfy the "unix" constraint.
+var unixOS = map[string]bool{
+	"aix": true, "android": true, "darwin": true, "dragonfly": true,
+	"freebsd": true, "hurd": true, "illumos": true, "ios": true,
+	"linux": true, "netbsd": true, "openbsd": true, "solaris": true,
+}
+
+// matchCgoTag reports whether the build tag name, which may be
+// negated with "!", holds for the target.  A release tag such as
+// "go1.21" holds if the Go toolchain rasta was built with has it.
+func matchCgoTag(name string) bool {
+	if strings.HasPrefix(name, "!") {
+		name = name[1:]
+		return name != "" && !strings.HasPrefix(name, "!") && !matchCgoTag(name)
+	}
+	switch name {
+	case "":
+		return false
+	case goos, goarch, "cgo", "gc":
+		return true
+	case "unix":
+		return unixOS[goos]
+	case "linux":
+		return goos == "android"
+	case "darwin":
+		return goos == "ios"
+	case "solaris":
+		return goos == "illumos"
+	}
+	for _, tag := range build.Default.ReleaseTags {
+		if name == tag {
+			return true
+		}
+	}
+	return false
+}
+
+var safeBytes = []byte(`+-.,/0123456789:=ABCDEFGHIJKLMNOPQRSTUVWXYZ\_abcdefghijklmnopqrstuvwxyz`)
+
+// safeName reports whether the #cgo option s holds only characters
+// that cannot make the compiler or linker do more than take a flag.
+func safeName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x80 && bytes.IndexByte(safeBytes, c) < 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,181 @@
+package cgo
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setTarget makes goos/goarch the target for the rest of the test.
+func setTarget(t *testing.T, targetOS, targetArch string) {
+	oldOS, oldArch := goos, goarch
+	goos, goarch = targetOS, targetArch
+	t.Cleanup(func() { goos, goarch = oldOS, oldArch })
+}
+
+func TestMatchCgoCond(t *testing.T) {
+	release := build.Default.ReleaseTags[len(build.Default.ReleaseTags)-1]
+	tests := []struct {
+		goos, goarch string
+		cond         string
+		want         bool
+	}{
+		{"linux", "amd64", "linux", true},
+		{"linux", "amd64", "linux,amd64", true},
+		{"linux", "amd64", "linux,arm64", false},
+		{"linux", "amd64", "!windows", true},
+		{"linux", "amd64", "!linux", false},
+		{"linux", "amd64", "!!linux", false},
+		{"linux", "amd64", "!", false},
+		{"linux", "amd64", "", false},
+		{"linux", "amd64", "cgo", true},
+		{"linux", "amd64", "gc", true},
+		{"linux", "amd64", "unix", true},
+		{"windows", "amd64", "unix", false},
+		{"android", "arm64", "linux", true},
+		{"ios", "arm64", "darwin", true},
+		{"illumos", "amd64", "solaris", true},
+		{"linux", "amd64", "go1.1", true},
+		{"linux", "amd64", release, true},
+		{"linux", "amd64", "go1.999", false},
+		{"linux", "amd64", "!go1.999", true},
+		{"linux", "amd64", "go2", false},
+	}
+	for _, tt := range tests {
+		setTarget(t, tt.goos, tt.goarch)
+		if got := matchCgoCond(tt.cond); got != tt.want {
+			t.Errorf("matchCgoCond(%q) on %s/%s = %v, want %v", tt.cond, tt.goos, tt.goarch, got, tt.want)
+		}
+	}
+}
+
+func TestSafeName(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"-I/usr/include", true},
+		{"-DX=1", true},
+		{"-Wl,-rpath,/opt/lib", true},
+		{"", false},
+		{"-fplugin=x;rm", false},
+		{"`id`", false},
+		{"a b", false},
+		{"-I/usr/include/ünïcode", true},
+	}
+	for _, tt := range tests {
+		if got := safeName(tt.s); got != tt.want {
+			t.Errorf("safeName(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+// A fakeFlags is a FlagProvider that records the packages
+// it is asked for and gives their names back as flags.
+type fakeFlags struct {
+	packages []string
+}
+
+func (f *fakeFlags) Flags(packages []string) (cflags, ldflags []string, err error) {
+	f.packages = append(f.packages, packages...)
+	for _, name := range packages {
+		cflags = append(cflags, "-I/opt/"+name+"/include")
+		ldflags = append(ldflags, "-l"+name)
+	}
+	return cflags, ldflags, nil
+}
+
+func TestParseFlags(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name      string
+		preamble  string
+		packages  []string            // asked of the FlagProvider
+		cgoFlags  map[string][]string // recorded in _cgo_flags
+		gccOption []string            // passed to the compiler
+	}{
+		{
+			name:     "flags",
+			preamble: "#cgo CFLAGS: -DX=1 -O2\n#cgo LDFLAGS: -lm",
+			cgoFlags: map[string][]string{
+				"CFLAGS":  {"-DX=1", "-O2"},
+				"LDFLAGS": {"-lm"},
+			},
+			gccOption: []string{"-DX=1", "-O2"},
+		},
+		{
+			name:      "constraints",
+			preamble:  "#cgo linux,amd64 CFLAGS: -DA\n#cgo windows CFLAGS: -DB\n#cgo !windows CPPFLAGS: -DC\n#cgo go1.999 CFLAGS: -DD",
+			cgoFlags:  map[string][]string{"CFLAGS": {"-DA"}, "CPPFLAGS": {"-DC"}},
+			gccOption: []string{"-DA", "-DC"},
+		},
+		{
+			name:      "srcdir",
+			preamble:  "#cgo CFLAGS: -I${SRCDIR}/include\n#cgo LDFLAGS: -L${SRCDIR}/lib",
+			cgoFlags:  map[string][]string{"CFLAGS": {"-I./include"}, "LDFLAGS": {"-L./lib"}},
+			gccOption: []string{"-I" + dir + "/include"},
+		},
+		{
+			name:     "pkg-config",
+			preamble: "#cgo pkg-config: foo bar\n#cgo windows pkg-config: baz",
+			packages: []string{"foo", "bar"},
+			cgoFlags: map[string][]string{
+				"CFLAGS":  {"-I/opt/foo/include", "-I/opt/bar/include"},
+				"LDFLAGS": {"-lfoo", "-lbar"},
+			},
+			gccOption: []string{"-I/opt/foo/include", "-I/opt/bar/include"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var flags fakeFlags
+			p := NewPackage(nil, Options{GOOS: "linux", GOARCH: "amd64", Reproducible: true, TrimPath: dir, Flags: &flags})
+			f := &File{Preamble: tt.preamble + "\n#include <stdio.h>"}
+			p.ParseFlags(f, filepath.Join(dir, "x.go"))
+
+			if !reflect.DeepEqual(flags.packages, tt.packages) {
+				t.Errorf("FlagProvider asked for %q, want %q", flags.packages, tt.packages)
+			}
+			for k, want := range tt.cgoFlags {
+				if got := p.CgoFlags[k]; !reflect.DeepEqual(got, want) {
+					t.Errorf("CgoFlags[%s] = %q, want %q", k, got, want)
+				}
+			}
+			for k, got := range p.CgoFlags {
+				if _, ok := tt.cgoFlags[k]; !ok && len(got) > 0 {
+					t.Errorf("CgoFlags[%s] = %q, want none", k, got)
+				}
+			}
+			if !reflect.DeepEqual(p.GccOptions, tt.gccOption) {
+				t.Errorf("GccOptions = %q, want %q", p.GccOptions, tt.gccOption)
+			}
+			if strings.Contains(f.Preamble, "#cgo") || !strings.Contains(f.Preamble, "#include <stdio.h>") {
+				t.Errorf("Preamble after ParseFlags:\n%s", f.Preamble)
+			}
+		})
+	}
+}
+
+func TestPkgConfig(t *testing.T) {
+	if _, err := os.Stat("/bin/echo"); err != nil {
+		t.Skip("no /bin/echo")
+	}
+	// echo prints its arguments, so --cflags and --libs come
+	// back as flags, followed by the package names.
+	cflags, ldflags, err := PkgConfig{Program: "/bin/echo"}.Flags([]string{"foo", "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"--cflags", "foo", "bar"}; !reflect.DeepEqual(cflags, want) {
+		t.Errorf("cflags = %q, want %q", cflags, want)
+	}
+	if want := []string{"--libs", "foo", "bar"}; !reflect.DeepEqual(ldflags, want) {
+		t.Errorf("ldflags = %q, want %q", ldflags, want)
+	}
+	if _, _, err := (PkgConfig{Program: "/bin/echo"}).Flags([]string{"-foo"}); err == nil {
+		t.Errorf("PkgConfig accepted the package name -foo")
+	}
+}
@@ -15,21 +15,23 @@ import (
 	"debug/pe"
 	"encoding/binary"
 	"errors"
-	"flag"
 	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
 )
 
-var debugDefine = flag.Bool("debug-define", false, "print relevant #defines")
-var debugGcc = flag.Bool("debug-gcc", false, "print gcc invocations")
-
 var nameToC = map[string]string{
 	"schar":         "signed char",
 	"uchar":         "unsigned char",
@@ -83,11 +85,92 @@ func (f *File) DiscardCgoDirectives() {
 	f.Preamble = strings.Join(linesOut, "\n")
 }
 
+// ParseFlags processes the #cgo directives in the import C preamble
+// of f, read from srcfile, as go build would: the CPPFLAGS, CFLAGS,
+// CXXFLAGS, FFLAGS and LDFLAGS they give, and the flags for the
+// packages they name with pkg-config, are added to p's flags.
+// A directive may be limited to some targets by build constraints
+// before its name, as in "#cgo linux,amd64 CFLAGS: -DX", and
+// ${SRCDIR} in it stands for the directory holding srcfile, trimmed
+// as file names are in the flags that Reproducible mode records.
+// The directives are then discarded, as by DiscardCgoDirectives.
+func (p *Package) ParseFlags(f *File, srcfile string) {
+	srcdir := filepath.Dir(absPath(srcfile))
+	linesIn := strings.Split(f.Preamble, "\n")
+	linesOut := make([]string, 0, len(linesIn))
+NextLine:
+	for _, line := range linesIn {
+		l := strings.TrimSpace(line)
+		if len(l) < 5 || l[:4] != "#cgo" || !unicode.IsSpace(rune(l[4])) {
+			linesOut = append(linesOut, line)
+			continue
+		}
+		linesOut = append(linesOut, "")
+
+		l = strings.TrimSpace(l[4:])
+		fields := strings.SplitN(l, ":", 2)
+		if len(fields) != 2 {
+			fatalf("%s: bad #cgo line: %s", srcfile, line)
+		}
+		kf := strings.Fields(fields[0])
+		if len(kf) == 0 {
+			fatalf("%s: bad #cgo line: %s", srcfile, line)
+		}
+		k := kf[len(kf)-1]
+		if conds := kf[:len(kf)-1]; len(conds) > 0 && !matchCgoConds(conds) {
+			continue NextLine
+		}
+		args, err := splitQuoted(fields[1])
+		if err != nil {
+			fatalf("%s: bad #cgo option %s: %s", srcfile, k, err)
+		}
+		recorded := make([]string, len(args))
+		for i, arg := range args {
+			recorded[i] = strings.Replace(arg, "${SRCDIR}", p.trimPath(srcdir), -1)
+			arg = strings.Replace(arg, "${SRCDIR}", srcdir, -1)
+			if !safeName(arg) {
+				fatalf("%s: #cgo option %s is unsafe: %s", srcfile, k, arg)
+			}
+			args[i] = arg
+		}
+		recorded = p.trimFlags(recorded)
+
+		switch k {
+		case "CPPFLAGS", "CFLAGS", "CXXFLAGS", "FFLAGS", "LDFLAGS":
+			p.CgoFlags[k] = append(p.CgoFlags[k], recorded...)
+			if k == "CFLAGS" || k == "CPPFLAGS" {
+				// gcc, run here, needs the directory itself.
+				p.GccOptions = append(p.GccOptions, args...)
+			}
+		case "pkg-config":
+			flags := p.Flags
+			if flags == nil {
+				flags = PkgConfig{}
+			}
+			cflags, ldflags, err := flags.Flags(args)
+			if err != nil {
+				fatalf("%s: bad #cgo option %s: %s", srcfile, k, err)
+			}
+			for _, flag := range append(cflags[:len(cflags):len(cflags)], ldflags...) {
+				if !safeName(flag) {
+					fatalf("%s: #cgo %s flag for %s is unsafe: %s", srcfile, k, strings.Join(args, " "), flag)
+				}
+			}
+			p.addToFlag("CFLAGS", cflags)
+			p.addToFlag("LDFLAGS", ldflags)
+		default:
+			fatalf("%s: unsupported #cgo option %s", srcfile, k)
+		}
+	}
+	f.Preamble = strings.Join(linesOut, "\n")
+}
+
 // addToFlag appends args to flag.  All flags are later written out onto the
-// _cgo_flags file for the build system to use.
+// _cgo_flags file for the build system to use, with their file names
+// trimmed in Reproducible mode.
 func (p *Package) addToFlag(flag string, args []string) {
-	p.CgoFlags[flag] = append(p.CgoFlags[flag], args...)
-	if flag == "CFLAGS" {
+	p.CgoFlags[flag] = append(p.CgoFlags[flag], p.trimFlags(args)...)
+	if flag == "CFLAGS" || flag == "CPPFLAGS" {
 		// We'll also need these when preprocessing for dwarf information.
 		p.GccOptions = append(p.GccOptions, args...)
 	}
@@ -163,11 +246,16 @@ func (p *Package) Translate(f *File) {
 		cref.Name.C = cname(cref.Name.Go)
 	}
 	p.loadDefines(f)
-	needType := p.guessKinds(f)
-	if len(needType) > 0 {
-		p.loadDWARF(f, needType)
+	if p.ParseHeaders {
+		p.loadHeaders(f)
+	} else {
+		needType := p.guessKinds(f)
+		if len(needType) > 0 {
+			p.loadDWARF(f, needType)
+		}
 	}
 	p.rewriteRef(f)
+	dropMacroCalls(f)
 }
 
 // loadDefines coerces gcc into spitting out the #defines in use
@@ -178,6 +266,7 @@ func (p *Package) loadDefines(f *File) {
 	b.WriteString(builtinProlog)
 	stdout := p.gccDefines(b.Bytes())
 
+	f.defines = make(map[string]string)
 	for _, line := range strings.Split(stdout, "\n") {
 		if len(line) < 9 || line[0:7] != "#define" {
 			continue
@@ -186,10 +275,26 @@ func (p *Package) loadDefines(f *File) {
 		line = strings.TrimSpace(line[8:])
 
 		var key, val string
+		var params []string
 		spaceIndex := strings.Index(line, " ")
 		tabIndex := strings.Index(line, "\t")
+		parenIndex := strings.Index(line, "(")
 
-		if spaceIndex == -1 && tabIndex == -1 {
+		if parenIndex > 0 && isName(line[:parenIndex]) {
+			// Function-like macro: NAME(params) body.
+			end := strings.Index(line, ")")
+			if end < 0 {
+				continue
+			}
+			key = line[:parenIndex]
+			params = []string{}
+			for _, param := range strings.Split(line[parenIndex+1:end], ",") {
+				if param = strings.TrimSpace(param); param != "" {
+					params = append(params, param)
+				}
+			}
+			val = strings.TrimSpace(line[end+1:])
+		} else if spaceIndex == -1 && tabIndex == -1 {
 			continue
 		} else if tabIndex == -1 || (spaceIndex != -1 && spaceIndex < tabIndex) {
 			key = line[0:spaceIndex]
@@ -203,15 +308,66 @@ func (p *Package) loadDefines(f *File) {
 			p.GccIsClang = true
 		}
 
+		if params == nil && val != "" {
+			f.defines[key] = val
+		}
+
 		if n := f.Name[key]; n != nil {
-			if *debugDefine {
-				fmt.Fprintf(os.Stderr, "#define %s %s\n", key, val)
+			if p.DebugDefine {
+				if params != nil {
+					fmt.Fprintf(os.Stderr, "#define %s(%s) %s\n", key, strings.Join(params, ","), val)
+				} else {
+					fmt.Fprintf(os.Stderr, "#define %s %s\n", key, val)
+				}
 			}
 			n.Define = val
+			if params != nil {
+				// Function-like macros have no Go equivalent.
+				// They are described as they are, not translated.
+				n.Kind = "macro"
+				n.Params = params
+			}
 		}
 	}
 }
 
+// defineConst reports whether the #define for n can be
+// translated as a constant value, and if so records that value in n.
+// Besides plain integers and quoted literals, it evaluates constant
+// expressions with hp: integers with suffixes, floating-point numbers,
+// arithmetic, shifts and casts, and references to other such macros.
+func (p *Package) defineConst(n *Name, hp *headerParser) bool {
+	isConst := false
+	if _, err := strconv.Atoi(n.Define); err == nil {
+		isConst = true
+	} else if n.Define[0] == '"' || n.Define[0] == '\'' {
+		if _, err := parser.ParseExpr(n.Define); err == nil {
+			isConst = true
+		}
+	}
+	if !isConst {
+		v, ok := hp.evalConst(n.Define)
+		if !ok {
+			return false
+		}
+		n.Kind = "const"
+		n.Const = v.String()
+		return true
+	}
+	n.Kind = "const"
+	// Turn decimal into hex, just for consistency
+	// with enum-derived constants.  Otherwise
+	// in the cgo -godefs output half the constants
+	// are in hex and half are in whatever the #define used.
+	i, err := strconv.ParseInt(n.Define, 0, 64)
+	if err == nil {
+		n.Const = fmt.Sprintf("%#x", i)
+	} else {
+		n.Const = n.Define
+	}
+	return true
+}
+
 // guessKinds tricks gcc into revealing the kind of each
 // name xxx for the references C.xxx in the Go input.
 // The kind is either a constant, type, or variable.
@@ -219,31 +375,17 @@ func (p *Package) guessKinds(f *File) []*Name {
 	// Determine kinds for names we already know about,
 	// like #defines or 'struct foo', before bothering with gcc.
 	var names, needType []*Name
+	hp := newHeaderParser(p)
+	hp.defines = f.defines
 	for _, key := range nameKeys(f.Name) {
 		n := f.Name[key]
+		if n.Kind == "macro" {
+			continue
+		}
 		// If we've already found this name as a #define
 		// and we can translate it as a constant value, do so.
 		if n.Define != "" {
-			isConst := false
-			if _, err := strconv.Atoi(n.Define); err == nil {
-				isConst = true
-			} else if n.Define[0] == '"' || n.Define[0] == '\'' {
-				if _, err := parser.ParseExpr(n.Define); err == nil {
-					isConst = true
-				}
-			}
-			if isConst {
-				n.Kind = "const"
-				// Turn decimal into hex, just for consistency
-				// with enum-derived constants.  Otherwise
-				// in the cgo -godefs output half the constants
-				// are in hex and half are in whatever the #define used.
-				i, err := strconv.ParseInt(n.Define, 0, 64)
-				if err == nil {
-					n.Const = fmt.Sprintf("%#x", i)
-				} else {
-					n.Const = n.Define
-				}
+			if p.defineConst(n, hp) {
 				continue
 			}
 
@@ -456,6 +598,16 @@ func (p *Package) loadDWARF(f *File, names []*Name) {
 	for _, ref := range f.Ref {
 		nameToRef[ref.Name] = ref
 	}
+	// With p.AllEnums, every enum and typedef in the headers
+	// is a candidate for Enums.  Otherwise only the enums with
+	// an enumerator we refer to are, along with their typedefs.
+	var enumTypes []dwarf.Offset
+	referenced := make(map[string]bool)
+	for _, n := range names {
+		referenced[n.C] = true
+	}
+	var usedEnums []dwarf.Offset
+	typedefsOf := make(map[dwarf.Offset][]dwarf.Offset) // by the type they name
 	r := d.Reader()
 	for {
 		e, err := r.Next()
@@ -466,8 +618,18 @@ func (p *Package) loadDWARF(f *File, names []*Name) {
 			break
 		}
 		switch e.Tag {
+		case dwarf.TagTypedef:
+			if p.AllEnums {
+				enumTypes = append(enumTypes, e.Offset)
+			} else if typOff, ok := e.Val(dwarf.AttrType).(dwarf.Offset); ok {
+				typedefsOf[typOff] = append(typedefsOf[typOff], e.Offset)
+			}
 		case dwarf.TagEnumerationType:
 			offset := e.Offset
+			if p.AllEnums {
+				enumTypes = append(enumTypes, offset)
+			}
+			used := false
 			for {
 				e, err := r.Next()
 				if err != nil {
@@ -478,6 +640,9 @@ func (p *Package) loadDWARF(f *File, names []*Name) {
 				}
 				if e.Tag == dwarf.TagEnumerator {
 					entryName := e.Val(dwarf.AttrName).(string)
+					if referenced[entryName] {
+						used = true
+					}
 					if strings.HasPrefix(entryName, "__cgo_enum__") {
 						n, _ := strconv.Atoi(entryName[len("__cgo_enum__"):])
 						if 0 <= n && n < len(names) {
@@ -486,6 +651,9 @@ func (p *Package) loadDWARF(f *File, names []*Name) {
 					}
 				}
 			}
+			if used && !p.AllEnums {
+				usedEnums = append(usedEnums, offset)
+			}
 		case dwarf.TagVariable:
 			name, _ := e.Val(dwarf.AttrName).(string)
 			typOff, _ := e.Val(dwarf.AttrType).(dwarf.Offset)
@@ -521,10 +689,14 @@ func (p *Package) loadDWARF(f *File, names []*Name) {
 			r.SkipChildren()
 		}
 	}
+	for _, off := range usedEnums {
+		enumTypes = append(enumTypes, off)
+		enumTypes = append(enumTypes, typedefsOf[off]...)
+	}
 
 	// Record types and typedef information.
 	var conv typeConv
-	conv.Init(p.PtrSize, p.IntSize)
+	conv.Init(p)
 	for i, n := range names {
 		if types[i] == nil {
 			continue
@@ -554,6 +726,21 @@ func (p *Package) loadDWARF(f *File, names []*Name) {
 		}
 		conv.FinishType(pos)
 	}
+
+	// Convert the remaining enums so that they are recorded in enumDefs.
+	for _, off := range enumTypes {
+		typ, err := d.Type(off)
+		if err != nil {
+			fatalf("loading DWARF type: %s", err)
+		}
+		if dt, ok := typ.(*dwarf.TypedefType); ok {
+			if _, ok := dt.Type.(*dwarf.EnumType); !ok {
+				continue
+			}
+		}
+		conv.Type(typ, token.NoPos)
+		conv.FinishType(token.NoPos)
+	}
 }
 
 // mangleName does name mangling to translate names
@@ -564,7 +751,7 @@ func (p *Package) mangleName(n *Name) {
 	// exported so that they become global symbols
 	// that the C code can refer to.
 	prefix := "_C"
-	if *gccgo && n.IsVar() {
+	if p.Gccgo && n.IsVar() {
 		prefix = "C"
 	}
 	n.Mangle = prefix + n.Kind + "_" + n.Go
@@ -572,7 +759,7 @@ func (p *Package) mangleName(n *Name) {
 
 // rewriteRef rewrites all the C.xxx references in f.AST to refer to the
 // Go equivalents, now that we have figured out the meaning of all
-// the xxx.  In *godefs mode, rewriteRef replaces the names
+// the xxx.  In godefs mode, rewriteRef replaces the names
 // with full definitions instead of mangled names.
 func (p *Package) rewriteRef(f *File) {
 	// Keep a list of all the functions, to remove the ones
@@ -604,6 +791,12 @@ func (p *Package) rewriteRef(f *File) {
 		var expr ast.Expr = ast.NewIdent(r.Name.Mangle) // default
 		switch r.Context {
 		case "call", "call2":
+			if r.Name.Kind == "macro" {
+				// Left as a call of the mangled name for
+				// dropMacroCalls; the macro itself is
+				// described by its Define and Params.
+				break
+			}
 			if r.Name.Kind != "func" {
 				if r.Name.Kind == "type" {
 					r.Context = "type"
@@ -685,7 +878,7 @@ func (p *Package) rewriteRef(f *File) {
 				error_(r.Pos(), "must call C.%s", fixGo(r.Name.Go))
 			}
 		}
-		if *godefs {
+		if p.Godefs {
 			// Substitute definition for mangled type name.
 			if id, ok := expr.(*ast.Ident); ok {
 				if t := typedef[id.Name]; t != nil {
@@ -718,11 +911,84 @@ func (p *Package) rewriteRef(f *File) {
 	}
 }
 
+// dropMacroCalls removes from f.AST the statements and the
+// package-level var and const specs that call a function-like
+// macro, since Go cannot call them.  The calls stay in f.Ref,
+// so that the macros are still described.
+func dropMacroCalls(f *File) {
+	calls := make(map[ast.Expr]bool)
+	for _, r := range f.Ref {
+		if r.Name.Kind == "macro" && (r.Context == "call" || r.Context == "call2") {
+			calls[*r.Expr] = true
+		}
+	}
+	if len(calls) == 0 {
+		return
+	}
+	callsMacro := func(n ast.Node) bool {
+		found := false
+		ast.Inspect(n, func(n ast.Node) bool {
+			if call, ok := n.(*ast.CallExpr); ok && calls[call.Fun] {
+				found = true
+			}
+			return !found
+		})
+		return found
+	}
+
+	var decls []ast.Decl
+	for _, d := range f.AST.Decls {
+		if g, ok := d.(*ast.GenDecl); ok && (g.Tok == token.VAR || g.Tok == token.CONST) {
+			var specs []ast.Spec
+			for _, s := range g.Specs {
+				if !callsMacro(s) {
+					specs = append(specs, s)
+				}
+			}
+			if len(specs) == 0 {
+				continue
+			}
+			g.Specs = specs
+		}
+		decls = append(decls, d)
+	}
+	f.AST.Decls = decls
+
+	// Filter the innermost statement lists first, so that
+	// a call drops only the statement that makes it.
+	var lists []*[]ast.Stmt
+	ast.Inspect(f.AST, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			lists = append(lists, &n.List)
+		case *ast.CaseClause:
+			lists = append(lists, &n.Body)
+		case *ast.CommClause:
+			lists = append(lists, &n.Body)
+		}
+		return true
+	})
+	for i := len(lists) - 1; i >= 0; i-- {
+		var stmts []ast.Stmt
+		for _, s := range *lists[i] {
+			if !callsMacro(s) {
+				stmts = append(stmts, s)
+			}
+		}
+		*lists[i] = stmts
+	}
+}
+
 // gccBaseCmd returns the start of the compiler command line.
 // It uses $CC if set, or else $GCC, or else the compiler recorded
 // during the initial build as defaultCC.
 // defaultCC is defined in zdefaultcc.go, written by cmd/dist.
 func (p *Package) gccBaseCmd() []string {
+	// A compiler for this particular target comes first,
+	// as with cmd/dist's $CC_FOR_goos_goarch.
+	if ret := strings.Fields(os.Getenv("CC_FOR_" + goos + "_" + goarch)); len(ret) > 0 {
+		return ret
+	}
 	// Use $CC if set, since that's what the build uses.
 	if ret := strings.Fields(os.Getenv("CC")); len(ret) > 0 {
 		return ret
@@ -734,37 +1000,106 @@ func (p *Package) gccBaseCmd() []string {
 	return strings.Fields(defaultCC)
 }
 
-// gccMachine returns the gcc -m flag to use, either "-m32", "-m64" or "-marm".
+// gccMachine returns the C compiler flags that select the target
+// architecture, such as "-m32", "-m64" or "-marm".
+// When cross-compiling with clang it returns a -target flag instead.
+// No flag makes a host compiler other than clang compile for another
+// operating system, so gccMachine stops in that case unless
+// $CC_FOR_goos_goarch names a compiler for the target.
 func (p *Package) gccMachine() []string {
-	switch goarch {
-	case "amd64":
-		return []string{"-m64"}
-	case "386":
-		return []string{"-m32"}
-	case "arm":
-		return []string{"-marm"} // not thumb
-	case "s390":
-		return []string{"-m31"}
-	case "s390x":
-		return []string{"-m64"}
-	}
-	return nil
+	if goos == runtime.GOOS && goarch == runtime.GOARCH {
+		return p.Target.Machine
+	}
+	if p.gccIsClang() {
+		if t := p.Target.ClangTriple(goos); t != "" {
+			return []string{"-target", t}
+		}
+	}
+	if goos != runtime.GOOS && os.Getenv("CC_FOR_"+goos+"_"+goarch) == "" {
+		fatalf("%s compiles for %s, not for %s/%s: set CC_FOR_%s_%s to a C compiler for the target, or use clang",
+			p.gccBaseCmd()[0], runtime.GOOS, goos, goarch, goos, goarch)
+	}
+	return p.Target.Machine
+}
+
+// checkFormat stops if the object file format, "ELF", "Mach-O"
+// or "PE", is not the one that C compilers use on the target.
+func (p *Package) checkFormat(format string) {
+	want := "ELF"
+	switch goos {
+	case "darwin", "ios":
+		want = "Mach-O"
+	case "windows":
+		want = "PE"
+	case "aix", "js", "wasip1":
+		// XCOFF and WebAssembly objects are not read at all.
+		return
+	}
+	if format != want {
+		fatalf("%s compiles %s objects, not the %s objects of %s/%s: set CC_FOR_%s_%s to a C compiler for the target, or use clang",
+			p.gccBaseCmd()[0], format, want, goos, goarch, goos, goarch)
+	}
 }
 
-func gccTmp() string {
-	return *objDir + "_cgo_.o"
+// checkELF stops if the object file f was compiled for another
+// architecture than the target, as when a host gcc has no flag
+// in gccMachine that selects the target.
+func (p *Package) checkELF(f *elf.File) {
+	t := p.Target
+	if t.ELF == elf.EM_NONE {
+		return
+	}
+	class := elf.ELFCLASS32
+	if t.PtrSize == 8 {
+		class = elf.ELFCLASS64
+	}
+	order := binary.ByteOrder(binary.LittleEndian)
+	if t.BigEndian {
+		order = binary.BigEndian
+	}
+	if f.Machine != t.ELF || f.Class != class || f.ByteOrder != order {
+		fatalf("%s compiles for %s %s %s, not for %s/%s: set CC_FOR_%s_%s to a C compiler for the target, or use clang",
+			p.gccBaseCmd()[0], f.Class, f.Machine, f.ByteOrder, goos, goarch, goos, goarch)
+	}
+}
+
+// gccIsClang reports whether the C compiler is clang,
+// before any output from it has said so.
+func (p *Package) gccIsClang() bool {
+	return p.GccIsClang || strings.Contains(filepath.Base(p.gccBaseCmd()[0]), "clang")
+}
+
+func (p *Package) gccTmp() string {
+	if p.Sink == nil {
+		return p.objFile("_cgo_.o")
+	}
+	// The output goes to the Sink, but gcc needs a real file:
+	// keep it out of the way.
+	if p.gccTmpFile == "" {
+		f, err := ioutil.TempFile("", "_cgo_")
+		if err != nil {
+			fatalf("%s", err)
+		}
+		f.Close()
+		p.gccTmpFile = f.Name()
+	}
+	return p.gccTmpFile
 }
 
 // gccCmd returns the gcc command line to use for compiling
 // the input.
 func (p *Package) gccCmd() []string {
 	c := append(p.gccBaseCmd(),
-		"-w",          // no warnings
-		"-Wno-error",  // warnings are not errors
-		"-o"+gccTmp(), // write object to tmp
-		"-gdwarf-2",   // generate DWARF v2 debugging symbols
-		"-c",          // do not link
-		"-xc",         // input language is C
+		"-w",            // no warnings
+		"-Wno-error",    // warnings are not errors
+		"-o"+p.gccTmp(), // write object to tmp
+		"-gdwarf-2",     // generate DWARF v2 debugging symbols
+		"-c",            // do not link
+		"-xc",           // input language is C
+		// Keep enums in the DWARF output even if nothing uses
+		// them, so that a reference to one enumerator brings in
+		// the whole enum.
+		"-fno-eliminate-unused-debug-types",
 	)
 	if p.GccIsClang {
 		c = append(c,
@@ -795,18 +1130,27 @@ func (p *Package) gccCmd() []string {
 // gccDebug runs gcc -gdwarf-2 over the C program stdin and
 // returns the corresponding DWARF data and, if present, debug data block.
 func (p *Package) gccDebug(stdin []byte) (*dwarf.Data, binary.ByteOrder, []byte) {
-	runGcc(stdin, p.gccCmd())
+	p.runGcc(stdin, p.gccCmd())
+	if p.Sink != nil {
+		// The DWARF is read before returning: the temporary
+		// object file is no longer needed after that.
+		defer func() {
+			os.Remove(p.gccTmpFile)
+			p.gccTmpFile = ""
+		}()
+	}
 
 	isDebugData := func(s string) bool {
 		// Some systems use leading _ to denote non-assembly symbols.
 		return s == "__cgodebug_data" || s == "___cgodebug_data"
 	}
 
-	if f, err := macho.Open(gccTmp()); err == nil {
+	if f, err := macho.Open(p.gccTmp()); err == nil {
 		defer f.Close()
+		p.checkFormat("Mach-O")
 		d, err := f.DWARF()
 		if err != nil {
-			fatalf("cannot load DWARF output from %s: %v", gccTmp(), err)
+			fatalf("cannot load DWARF output from %s: %v", p.gccTmp(), err)
 		}
 		var data []byte
 		if f.Symtab != nil {
@@ -828,11 +1172,13 @@ func (p *Package) gccDebug(stdin []byte) (*dwarf.Data, binary.ByteOrder, []byte)
 		return d, f.ByteOrder, data
 	}
 
-	if f, err := elf.Open(gccTmp()); err == nil {
+	if f, err := elf.Open(p.gccTmp()); err == nil {
 		defer f.Close()
+		p.checkFormat("ELF")
+		p.checkELF(f)
 		d, err := f.DWARF()
 		if err != nil {
-			fatalf("cannot load DWARF output from %s: %v", gccTmp(), err)
+			fatalf("cannot load DWARF output from %s: %v", p.gccTmp(), err)
 		}
 		var data []byte
 		symtab, err := f.Symbols()
@@ -855,11 +1201,12 @@ func (p *Package) gccDebug(stdin []byte) (*dwarf.Data, binary.ByteOrder, []byte)
 		return d, f.ByteOrder, data
 	}
 
-	if f, err := pe.Open(gccTmp()); err == nil {
+	if f, err := pe.Open(p.gccTmp()); err == nil {
 		defer f.Close()
+		p.checkFormat("PE")
 		d, err := f.DWARF()
 		if err != nil {
-			fatalf("cannot load DWARF output from %s: %v", gccTmp(), err)
+			fatalf("cannot load DWARF output from %s: %v", p.gccTmp(), err)
 		}
 		var data []byte
 		for _, s := range f.Symbols {
@@ -877,7 +1224,7 @@ func (p *Package) gccDebug(stdin []byte) (*dwarf.Data, binary.ByteOrder, []byte)
 		return d, binary.LittleEndian, data
 	}
 
-	fatalf("cannot parse gcc output %s as ELF, Mach-O, PE object", gccTmp())
+	fatalf("cannot parse gcc output %s as ELF, Mach-O, PE object", p.gccTmp())
 	panic("not reached")
 }
 
@@ -888,7 +1235,20 @@ func (p *Package) gccDebug(stdin []byte) (*dwarf.Data, binary.ByteOrder, []byte)
 func (p *Package) gccDefines(stdin []byte) string {
 	base := append(p.gccBaseCmd(), "-E", "-dM", "-xc")
 	base = append(base, p.gccMachine()...)
-	stdout, _ := runGcc(stdin, append(append(base, p.GccOptions...), "-"))
+	stdout, _ := p.runGcc(stdin, append(append(base, p.GccOptions...), "-"))
+	return stdout
+}
+
+// gccPreprocess runs gcc -E -xc - over the C program stdin
+// and returns the preprocessed source.  The headers would
+// otherwise seem empty, so it stops if gcc reports errors.
+func (p *Package) gccPreprocess(stdin []byte) string {
+	base := append(p.gccBaseCmd(), "-E", "-xc")
+	base = append(base, p.gccMachine()...)
+	stdout, stderr := p.runGcc(stdin, append(append(base, p.GccOptions...), "-"))
+	if strings.Contains(stderr, "error:") || strings.TrimSpace(stdout) == "" {
+		fatalf("preprocessing the C preamble failed:\n%s", stderr)
+	}
 	return stdout
 }
 
@@ -899,13 +1259,13 @@ func (p *Package) gccErrors(stdin []byte) string {
 	// TODO(rsc): require failure
 	args := p.gccCmd()
 
-	if *debugGcc {
+	if p.DebugGcc {
 		fmt.Fprintf(os.Stderr, "$ %s <<EOF\n", strings.Join(args, " "))
 		os.Stderr.Write(stdin)
 		fmt.Fprint(os.Stderr, "EOF\n")
 	}
 	stdout, stderr, _ := run(stdin, args)
-	if *debugGcc {
+	if p.DebugGcc {
 		os.Stderr.Write(stdout)
 		os.Stderr.Write(stderr)
 	}
@@ -918,14 +1278,14 @@ func (p *Package) gccErrors(stdin []byte) string {
 // Otherwise runGcc returns the data written to standard output and standard error.
 // Note that for some of the uses we expect useful data back
 // on standard error, but for those uses gcc must still exit 0.
-func runGcc(stdin []byte, args []string) (string, string) {
-	if *debugGcc {
+func (p *Package) runGcc(stdin []byte, args []string) (string, string) {
+	if p.DebugGcc {
 		fmt.Fprintf(os.Stderr, "$ %s <<EOF\n", strings.Join(args, " "))
 		os.Stderr.Write(stdin)
 		fmt.Fprint(os.Stderr, "EOF\n")
 	}
 	stdout, stderr, ok := run(stdin, args)
-	if *debugGcc {
+	if p.DebugGcc {
 		os.Stderr.Write(stdout)
 		os.Stderr.Write(stderr)
 	}
@@ -960,17 +1320,35 @@ type typeConv struct {
 	goVoid                                 ast.Expr // _Ctype_void, denotes C's void
 	goVoidPtr                              ast.Expr // unsafe.Pointer or *byte
 
-	ptrSize int64
-	intSize int64
+	ptrSize      int64
+	intSize      int64
+	bigEndian    bool
+	godefs       bool // map types as for Options.Godefs
+	reproducible bool // name anonymous types as for Options.Reproducible
 }
 
 var tagGen int
+
+// contentTag returns a tag for the anonymous struct, union or class dt
+// derived from its layout, so that it does not depend on the order in
+// which types are converted.  Identical anonymous types share a tag.
+func contentTag(dt *dwarf.StructType) string {
+	h := fnv.New64a()
+	io.WriteString(h, dt.Defn())
+	return fmt.Sprintf("%016x", h.Sum64())[:12]
+}
+
 var typedef = make(map[string]*Type)
+var enumDefs = make(map[string]*Enum)
+var enumOf = make(map[string]*Enum) // by enumerator name
 var goIdent = make(map[string]*ast.Ident)
 
-func (c *typeConv) Init(ptrSize, intSize int64) {
-	c.ptrSize = ptrSize
-	c.intSize = intSize
+func (c *typeConv) Init(p *Package) {
+	c.ptrSize = p.PtrSize
+	c.intSize = p.IntSize
+	c.bigEndian = p.Target.BigEndian
+	c.godefs = p.Godefs
+	c.reproducible = p.Reproducible
 	c.m = make(map[dwarf.Type]*Type)
 	c.ptrs = make(map[dwarf.Type][]*Type)
 	c.bool = c.Ident("bool")
@@ -994,7 +1372,7 @@ func (c *typeConv) Init(ptrSize, intSize int64) {
 
 	// Normally cgo translates void* to unsafe.Pointer,
 	// but for historical reasons -godefs uses *byte instead.
-	if *godefs {
+	if c.godefs {
 		c.goVoidPtr = &ast.StarExpr{X: c.byte}
 	} else {
 		c.goVoidPtr = c.Ident("unsafe.Pointer")
@@ -1171,6 +1549,9 @@ func (c *typeConv) Type(dtype dwarf.Type, pos token.Pos) *Type {
 		case 8 + signedDelta:
 			t.Go = c.int64
 		}
+		if dt.EnumName != "" {
+			recordEnum("enum "+dt.EnumName, t, dt)
+		}
 
 	case *dwarf.FloatType:
 		switch t.Size {
@@ -1260,12 +1641,20 @@ func (c *typeConv) Type(dtype dwarf.Type, pos token.Pos) *Type {
 			break
 		}
 		if tag == "" {
-			tag = "__" + strconv.Itoa(tagGen)
-			tagGen++
+			if c.reproducible {
+				tag = "__" + contentTag(dt)
+			} else {
+				tag = "__" + strconv.Itoa(tagGen)
+				tagGen++
+			}
 		} else if t.C.Empty() {
 			t.C.Set(dt.Kind + " " + tag)
 		}
 		name := c.Ident("_Ctype_" + dt.Kind + "_" + tag)
+		if id := goIdent[name.Name]; id != nil && c.reproducible {
+			// The same anonymous type again, as named by contentTag.
+			name = id
+		}
 		t.Go = name // publish before recursive calls
 		goIdent[name.Name] = name
 		if dt.ByteSize < 0 {
@@ -1280,14 +1669,19 @@ func (c *typeConv) Type(dtype dwarf.Type, pos token.Pos) *Type {
 		}
 		switch dt.Kind {
 		case "class", "union":
-			t.Go = c.Opaque(t.Size)
+			// Go has no unions: the union is a byte array, and its
+			// members overlay it through the accessors in writeDefs.
 			if t.C.Empty() {
 				t.C.Set("__typeof__(unsigned char[%d])", t.Size)
 			}
 			t.Align = 1 // TODO: should probably base this on field alignment.
-			typedef[name.Name] = t
+			tt := *t
+			tt.Go = c.Opaque(t.Size)
+			tt.Fields = c.Union(dt, pos)
+			tt.Union = true
+			typedef[name.Name] = &tt
 		case "struct":
-			g, csyntax, align := c.Struct(dt, pos)
+			g, csyntax, align, fields := c.Struct(dt, pos)
 			if t.C.Empty() {
 				t.C.Set(csyntax)
 			}
@@ -1297,6 +1691,8 @@ func (c *typeConv) Type(dtype dwarf.Type, pos token.Pos) *Type {
 				tt.C = &TypeRepr{"struct %s", []interface{}{tag}}
 			}
 			tt.Go = g
+			tt.Fields = fields
+			tt.Packed = isPacked(fields)
 			typedef[name.Name] = &tt
 		}
 
@@ -1325,6 +1721,10 @@ func (c *typeConv) Type(dtype dwarf.Type, pos token.Pos) *Type {
 		t.Go = name
 		t.Size = sub.Size
 		t.Align = sub.Align
+		if et, ok := dt.Type.(*dwarf.EnumType); ok && et.EnumName == "" {
+			// Anonymous enums are known by their typedef name.
+			recordEnum(dt.Name, sub, et)
+		}
 		oldType := typedef[name.Name]
 		if oldType == nil {
 			tt := *t
@@ -1336,7 +1736,7 @@ func (c *typeConv) Type(dtype dwarf.Type, pos token.Pos) *Type {
 		// use that as the Go form for this typedef too, so that the typedef will be interchangeable
 		// with the base type.
 		// In -godefs mode, do this for all typedefs.
-		if isStructUnionClass(sub.Go) || *godefs {
+		if isStructUnionClass(sub.Go) || c.godefs {
 			t.Go = sub.Go
 
 			if isStructUnionClass(sub.Go) {
@@ -1398,7 +1798,7 @@ func (c *typeConv) Type(dtype dwarf.Type, pos token.Pos) *Type {
 			name := c.Ident("_Ctype_" + s)
 			tt := *t
 			typedef[name.Name] = &tt
-			if !*godefs {
+			if !c.godefs {
 				t.Go = name
 			}
 		}
@@ -1432,6 +1832,40 @@ func (c *typeConv) Type(dtype dwarf.Type, pos token.Pos) *Type {
 	return t
 }
 
+// recordEnum saves the enumerators of the C enum type dt
+// under the given name, for Enums.  An anonymous enum that
+// several typedefs name, as in typedef enum {...} a, b;, is
+// saved once, under the first of them: enumerator names are
+// unique in C, so they tell whether dt was seen already.
+func recordEnum(name string, t *Type, dt *dwarf.EnumType) {
+	if enumDefs[name] != nil {
+		return
+	}
+	if len(dt.Val) > 0 && enumOf[dt.Val[0].Name] != nil {
+		return
+	}
+	e := &Enum{Name: name, Type: t}
+	for _, ev := range dt.Val {
+		e.Enumerators = append(e.Enumerators, &Enumerator{Name: ev.Name, Value: ev.Val})
+		enumOf[ev.Name] = e
+	}
+	enumDefs[name] = e
+}
+
+// Enums returns the C enumeration types seen so far, sorted by name.
+func Enums() []*Enum {
+	names := make([]string, 0, len(enumDefs))
+	for name := range enumDefs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	enums := make([]*Enum, len(names))
+	for i, name := range names {
+		enums[i] = enumDefs[name]
+	}
+	return enums
+}
+
 // isStructUnionClass reports whether the type described by the Go syntax x
 // is a struct, union, or class with a tag.
 func isStructUnionClass(x ast.Expr) bool {
@@ -1554,7 +1988,7 @@ func (c *typeConv) pad(fld []*ast.Field, sizes []int64, size int64) ([]*ast.Fiel
 }
 
 // Struct conversion: return Go and (gc) C syntax for type.
-func (c *typeConv) Struct(dt *dwarf.StructType, pos token.Pos) (expr *ast.StructType, csyntax string, align int64) {
+func (c *typeConv) Struct(dt *dwarf.StructType, pos token.Pos) (expr *ast.StructType, csyntax string, align int64, fields []*StructField) {
 	// Minimum alignment for a struct is 1 byte.
 	align = 1
 
@@ -1562,6 +1996,7 @@ func (c *typeConv) Struct(dt *dwarf.StructType, pos token.Pos) (expr *ast.Struct
 	buf.WriteString("struct {")
 	fld := make([]*ast.Field, 0, 2*len(dt.Field)+1) // enough for padding around every field
 	sizes := make([]int64, 0, 2*len(dt.Field)+1)
+	fields = make([]*StructField, 0, len(dt.Field))
 	off := int64(0)
 
 	// Rename struct fields that happen to be named Go keywords into
@@ -1577,7 +2012,7 @@ func (c *typeConv) Struct(dt *dwarf.StructType, pos token.Pos) (expr *ast.Struct
 		used[f.Name] = true
 	}
 
-	if !*godefs {
+	if !c.godefs {
 		for cid, goid := range ident {
 			if token.Lookup(goid).IsKeyword() {
 				// Avoid keyword
@@ -1596,10 +2031,12 @@ func (c *typeConv) Struct(dt *dwarf.StructType, pos token.Pos) (expr *ast.Struct
 
 	anon := 0
 	for _, f := range dt.Field {
-		if f.ByteOffset > off {
-			fld, sizes = c.pad(fld, sizes, f.ByteOffset-off)
-			off = f.ByteOffset
+		sf := &StructField{Name: f.Name, Offset: f.ByteOffset, Type: c.Type(f.Type, pos)}
+		if id, ok := sf.Type.Go.(*ast.Ident); ok && f.Name == "" && typedef[id.Name] != nil {
+			// Describe an anonymous struct or union by its layout.
+			sf.Type = typedef[id.Name]
 		}
+		fields = append(fields, sf)
 
 		name := f.Name
 		ft := f.Type
@@ -1609,7 +2046,7 @@ func (c *typeConv) Struct(dt *dwarf.StructType, pos token.Pos) (expr *ast.Struct
 		// union as the field in the struct.  This handles
 		// cases like the glibc <sys/resource.h> file; see
 		// issue 6677.
-		if *godefs {
+		if c.godefs {
 			if st, ok := f.Type.(*dwarf.StructType); ok && name == "" && st.Kind == "union" && len(st.Field) > 0 && !used[st.Field[0].Name] {
 				name = st.Field[0].Name
 				ident[name] = name
@@ -1617,50 +2054,77 @@ func (c *typeConv) Struct(dt *dwarf.StructType, pos token.Pos) (expr *ast.Struct
 			}
 		}
 
-		// TODO: Handle fields that are anonymous structs by
-		// promoting the fields of the inner struct.
-
 		t := c.Type(ft, pos)
 		tgo := t.Go
+		offset := f.ByteOffset
 		size := t.Size
 		talign := t.Align
 		if f.BitSize > 0 {
-			if f.BitSize%8 != 0 {
+			sf.Offset, sf.BitOffset = c.bitField(f, t)
+			sf.BitSize = f.BitSize
+
+			// Only a bit-field filling whole, aligned bytes
+			// can be a Go integer field.  The others are left
+			// in the padding and reached through accessors.
+			w := f.BitSize
+			if w != 8 && w != 16 && w != 32 && w != 64 || sf.BitOffset%8 != 0 {
 				continue
 			}
-			size = f.BitSize / 8
-			name := tgo.(*ast.Ident).String()
-			if strings.HasPrefix(name, "int") {
-				name = "int"
-			} else {
-				name = "uint"
+			size = w / 8
+			offset = sf.Offset + sf.BitOffset/8
+			if c.bigEndian {
+				offset = sf.Offset + t.Size - (sf.BitOffset+w)/8
 			}
-			tgo = ast.NewIdent(name + fmt.Sprint(f.BitSize))
+			if offset%size != 0 {
+				continue
+			}
+			kind := "uint"
+			switch base(ft).(type) {
+			case *dwarf.IntType, *dwarf.CharType:
+				kind = "int"
+			}
+			tgo = ast.NewIdent(kind + fmt.Sprint(w))
 			talign = size
+		} else if talign > 0 && offset%talign != 0 {
+			// A misaligned field, as in a packed struct, cannot
+			// have its own type in Go.  Keep its bytes under its
+			// name, so that it is at least not lost.
+			tgo = c.Opaque(size)
+			talign = 1
 		}
 
-		if talign > 0 && f.ByteOffset%talign != 0 {
-			// Drop misaligned fields, the same way we drop integer bit fields.
-			// The goal is to make available what can be made available.
-			// Otherwise one bad and unneeded field in an otherwise okay struct
-			// makes the whole program not compile. Much of the time these
-			// structs are in system headers that cannot be corrected.
+		if offset < off {
+			// Overlaps a field already laid out.
 			continue
 		}
+		if offset > off {
+			fld, sizes = c.pad(fld, sizes, offset-off)
+			off = offset
+		}
+
 		n := len(fld)
 		fld = fld[0 : n+1]
-		if name == "" {
-			name = fmt.Sprintf("anon%d", anon)
+		cname := name
+		if cname == "" {
+			cname = fmt.Sprintf("anon%d", anon)
 			anon++
-			ident[name] = name
+			ident[cname] = cname
+		}
+		if id, ok := tgo.(*ast.Ident); ok && name == "" && !c.godefs && isStructUnionClass(id) {
+			// Embed anonymous structs and unions so that, as in C,
+			// their members are promoted to the enclosing struct.
+			fld[n] = &ast.Field{Type: tgo}
+			sf.Go = id.Name
+		} else {
+			fld[n] = &ast.Field{Names: []*ast.Ident{c.Ident(ident[cname])}, Type: tgo}
+			sf.Go = ident[cname]
 		}
-		fld[n] = &ast.Field{Names: []*ast.Ident{c.Ident(ident[name])}, Type: tgo}
 		sizes = sizes[0 : n+1]
 		sizes[n] = size
 		off += size
 		buf.WriteString(t.C.String())
 		buf.WriteString(" ")
-		buf.WriteString(name)
+		buf.WriteString(cname)
 		buf.WriteString("; ")
 		if talign > align {
 			align = talign
@@ -1689,7 +2153,7 @@ func (c *typeConv) Struct(dt *dwarf.StructType, pos token.Pos) (expr *ast.Struct
 	buf.WriteString("}")
 	csyntax = buf.String()
 
-	if *godefs {
+	if c.godefs {
 		godefsFields(fld)
 	}
 	expr = &ast.StructType{Fields: &ast.FieldList{List: fld}}
@@ -1707,6 +2171,56 @@ func upper(s string) string {
 	return string(unicode.ToUpper(r)) + s[size:]
 }
 
+// Union returns the members of the C union dt, which all start at offset 0.
+func (c *typeConv) Union(dt *dwarf.StructType, pos token.Pos) []*StructField {
+	fields := make([]*StructField, 0, len(dt.Field))
+	for _, f := range dt.Field {
+		t := c.Type(f.Type, pos)
+		sf := &StructField{Name: f.Name, Type: t}
+		if f.BitSize > 0 {
+			sf.Offset, sf.BitOffset = c.bitField(f, t)
+			sf.BitSize = f.BitSize
+		}
+		fields = append(fields, sf)
+	}
+	return fields
+}
+
+// bitField returns the position of the bit-field f of type t:
+// the byte offset of the storage unit holding it, and the offset
+// of its lowest bit within the value of that unit.
+func (c *typeConv) bitField(f *dwarf.StructField, t *Type) (offset, bitOffset int64) {
+	if f.ByteSize > 0 {
+		// DWARF 2 and 3 count DW_AT_bit_offset from the
+		// most significant bit of the storage unit.
+		return f.ByteOffset, f.ByteSize*8 - f.BitOffset - f.BitSize
+	}
+	// DWARF 4 counts DW_AT_data_bit_offset from the start of the struct.
+	// The storage unit is the aligned one holding the field, or, for a
+	// field of a packed struct that straddles aligned units, the one
+	// starting at the field's first byte.
+	offset = f.DataBitOffset / 8 / t.Size * t.Size
+	if f.DataBitOffset+f.BitSize > (offset+t.Size)*8 {
+		offset = f.DataBitOffset / 8
+	}
+	bitOffset = f.DataBitOffset - offset*8
+	if c.bigEndian {
+		bitOffset = t.Size*8 - bitOffset - f.BitSize
+	}
+	return offset, bitOffset
+}
+
+// isPacked reports whether any of fields is misaligned for its type,
+// as happens in structs declared with __attribute__((packed)).
+func isPacked(fields []*StructField) bool {
+	for _, f := range fields {
+		if f.BitSize == 0 && f.Type.Align > 0 && f.Offset%f.Type.Align != 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // godefsFields rewrites field names for use in Go or C definitions.
 // It strips leading common prefixes (like tv_ in tv_sec, tv_usec)
 // converts names to upper case, and rewrites _ into Pad_godefs_n,
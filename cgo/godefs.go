@@ -11,15 +11,48 @@ import (
 	"go/printer"
 	"go/token"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 )
 
+// GodefsOutput returns the Go definitions for f in godefs mode.
+func (p *Package) GodefsOutput(f *File, srcfile string) string {
+	for _, cref := range f.Ref {
+		switch cref.Context {
+		case "call", "call2":
+			if cref.Name.Kind != "type" {
+				break
+			}
+			*cref.Expr = cref.Name.Type.Go
+		}
+	}
+	return p.godefs(f, srcfile)
+}
+
+// TypedefNames returns the sorted names of the Go types
+// defined so far for C types, such as _Ctype_struct_foo.
+func TypedefNames() []string {
+	names := make([]string, 0, len(typedef))
+	for name := range typedef {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupTypedef returns the definition of the Go type name
+// created for a C type, or nil if there is none.
+func LookupTypedef(name string) *Type {
+	return typedef[name]
+}
+
 // godefs returns the output for -godefs mode.
 func (p *Package) godefs(f *File, srcfile string) string {
 	var buf bytes.Buffer
 
 	fmt.Fprintf(&buf, "// Created by cgo -godefs - DO NOT EDIT\n")
-	fmt.Fprintf(&buf, "// %s\n", strings.Join(os.Args, " "))
+	fmt.Fprintf(&buf, "// %s\n", p.CommandLine())
 	fmt.Fprintf(&buf, "\n")
 
 	override := make(map[string]string)
@@ -48,7 +81,8 @@ func (p *Package) godefs(f *File, srcfile string) string {
 			override["_Ctype_"+strings.TrimSpace(s[:i])] = strings.TrimSpace(s[i:])
 		}
 	}
-	for _, n := range f.Name {
+	for _, key := range nameKeys(f.Name) {
+		n := f.Name[key]
 		if s := override[n.Go]; s != "" {
 			override[n.Mangle] = s
 		}
@@ -61,6 +95,10 @@ func (p *Package) godefs(f *File, srcfile string) string {
 	for _, r := range f.Ref {
 		refName[r.Expr] = r.Name
 	}
+	// The structs and unions of those types, for their accessors,
+	// found before the overrides rename the C types.
+	var accessorNames []string
+	accessorTypes := make(map[string]*Type)
 	for _, d := range f.AST.Decls {
 		d, ok := d.(*ast.GenDecl)
 		if !ok || d.Tok != token.TYPE {
@@ -72,13 +110,18 @@ func (p *Package) godefs(f *File, srcfile string) string {
 			if n != nil && n.Mangle != "" {
 				override[n.Mangle] = s.Name.Name
 			}
+			if n != nil && n.Type != nil {
+				accessorNames = append(accessorNames, s.Name.Name)
+				accessorTypes[s.Name.Name] = underlyingStruct(n.Type)
+			}
 		}
 	}
 
 	// Extend overrides using typedefs:
 	// If we know that C.xxx should format as T
 	// and xxx is a typedef for yyy, make C.yyy format as T.
-	for typ, def := range typedef {
+	for _, typ := range TypedefNames() {
+		def := typedef[typ]
 		if new := override[typ]; new != "" {
 			if id, ok := def.Go.(*ast.Ident); ok {
 				override[id.Name] = new
@@ -87,7 +130,13 @@ func (p *Package) godefs(f *File, srcfile string) string {
 	}
 
 	// Apply overrides.
-	for old, new := range override {
+	olds := make([]string, 0, len(override))
+	for old := range override {
+		olds = append(olds, old)
+	}
+	sort.Strings(olds)
+	for _, old := range olds {
+		new := override[old]
 		if id := goIdent[old]; id != nil {
 			id.Name = new
 		}
@@ -109,11 +158,40 @@ func (p *Package) godefs(f *File, srcfile string) string {
 		}
 	}
 
-	conf.Fprint(&buf, fset, f.AST)
+	// Bit-fields and union members have no Go field,
+	// so they are reached through functions as in writeDefs.
+	var accessors bytes.Buffer
+	for _, name := range accessorNames {
+		writeAccessors(&accessors, name, accessorTypes[name])
+	}
+	if accessors.Len() > 0 {
+		addImport(f.AST, "unsafe")
+	}
+
+	// Line numbers are just noise.
+	cfg := conf
+	cfg.Mode &^= printer.SourcePos
+	cfg.Fprint(&buf, fset, f.AST)
+	if accessors.Len() > 0 {
+		fmt.Fprintf(&buf, "\n")
+		buf.Write(accessors.Bytes())
+	}
 
 	return buf.String()
 }
 
+// addImport adds an import of path to f unless f already has one.
+func addImport(f *ast.File, path string) {
+	for _, s := range f.Imports {
+		if s.Path.Value == strconv.Quote(path) {
+			return
+		}
+	}
+	spec := &ast.ImportSpec{Path: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(path)}}
+	f.Imports = append(f.Imports, spec)
+	f.Decls = append([]ast.Decl{&ast.GenDecl{Tok: token.IMPORT, Specs: []ast.Spec{spec}}}, f.Decls...)
+}
+
 var gofmtBuf bytes.Buffer
 
 // gofmt returns the gofmt-formatted string for an AST node.
@@ -15,7 +15,6 @@ import (
 	"flag"
 	"fmt"
 	"go/ast"
-	"go/printer"
 	"go/token"
 	"io"
 	"os"
@@ -28,20 +27,85 @@ import (
 
 // A Package collects information about the package we're going to write.
 type Package struct {
-	PackageName string // name of package
-	PackagePath string
-	PtrSize     int64
-	IntSize     int64
-	GccOptions  []string
-	GccIsClang  bool
-	CgoFlags    map[string][]string // #cgo flags (CFLAGS, LDFLAGS)
-	Written     map[string]bool
-	Name        map[string]*Name // accumulated Name from Files
-	ExpFunc     []*ExpFunc       // accumulated ExpFunc from Files
-	Decl        []ast.Decl
-	GoFiles     []string // list of Go files
-	GccFiles    []string // list of gcc output files
-	Preamble    string   // collected preamble for _cgo_export.h
+	PackageName  string // name of package
+	PackagePath  string
+	PtrSize      int64
+	IntSize      int64
+	Target       *Target // the architecture translated for
+	GccOptions   []string
+	GccIsClang   bool
+	AllEnums     bool                // describe every C enum in the headers, not just the referenced ones
+	EnumConsts   bool                // write a Go constant block for every C enum
+	ParseHeaders bool                // classify names by parsing the headers instead of compiling probes
+	CgoFlags     map[string][]string // #cgo flags (CFLAGS, LDFLAGS)
+	Written      map[string]bool
+	Name         map[string]*Name // accumulated Name from Files
+	ExpFunc      []*ExpFunc       // accumulated ExpFunc from Files
+	Decl         []ast.Decl
+	GoFiles      []string // list of Go files
+	GccFiles     []string // list of gcc output files
+	Preamble     string   // collected preamble for _cgo_export.h
+
+	Options // how to translate the package and where to write it
+
+	expStructs    map[string]*exportStruct // C definitions of Go struct types used by exports
+	expStructList []*exportStruct          // expStructs, in definition order
+	gccTmpFile    string                   // gcc object file, when there is a Sink
+}
+
+// Options control how a Package is translated and where its output
+// is written.  The zero Options writes gc output to the current directory.
+type Options struct {
+	ObjDir       string // directory for output files
+	ImportPath   string // import path of package being built (for comments in generated files)
+	ExportHeader string // where to write export header if any exported functions
+	Godefs       bool   // for bootstrap: map C types as for Go definitions of them
+	Gccgo        bool   // generate files for use with gccgo
+	GccgoPrefix  string // -fgo-prefix option used with gccgo
+	GccgoPkgPath string // -fgo-pkgpath option used with gccgo
+	NoRuntimeCgo bool   // do not import runtime/cgo in generated code
+	NoSyscall    bool   // do not import syscall in generated code
+	DebugDefine  bool   // print relevant #defines
+	DebugGcc     bool   // print gcc invocations
+	GOOS         string // target operating system; "" means $GOOS or the host's
+	GOARCH       string // target architecture; "" means $GOARCH or the host's
+
+	// Reproducible makes the output the same wherever and however
+	// often it is generated: file names are recorded as trimmed by
+	// TrimPath, or relative to the working directory if TrimPath is
+	// empty, and anonymous C types are named after their contents
+	// rather than the order in which they are found.
+	Reproducible bool
+	// TrimPath lists, separated by semicolons, the path prefixes to
+	// remove from file names in Reproducible mode.  A prefix may be
+	// followed by "=>" and a replacement, as for go tool compile -trimpath.
+	TrimPath string
+
+	// Flags supplies the flags for the packages named by #cgo
+	// pkg-config directives.  If it is nil, pkg-config is run.
+	Flags FlagProvider
+
+	// Sink receives the output files.  If it is nil, they are
+	// written to ObjDir, as by DirSink(ObjDir).
+	Sink OutputSink
+}
+
+// objFile returns the name of the output file name in p.ObjDir.
+func (p *Package) objFile(name string) string {
+	return filepath.Join(p.ObjDir, name)
+}
+
+// create creates the output file name in p's Sink.
+func (p *Package) create(name string) io.WriteCloser {
+	sink := p.Sink
+	if sink == nil {
+		sink = DirSink(p.ObjDir)
+	}
+	w, err := sink.Create(name)
+	if err != nil {
+		fatalf("%s", err)
+	}
+	return w
 }
 
 // A File collects information about a single Go input file.
@@ -53,6 +117,8 @@ type File struct {
 	Ref      []*Ref              // all references to C.xxx in AST
 	ExpFunc  []*ExpFunc          // exported functions for this file
 	Name     map[string]*Name    // map from Go name to Name
+
+	defines map[string]string // object-like #defines visible to the preamble
 }
 
 func nameKeys(m map[string]*Name) []string {
@@ -81,11 +147,12 @@ type Name struct {
 	Mangle   string // name used in generated Go
 	C        string // name used in C
 	Define   string // #define expansion
-	Kind     string // "const", "type", "var", "fpvar", "func", "not-type"
+	Kind     string // "const", "type", "var", "fpvar", "func", "macro", "not-type"
 	Type     *Type  // the type of xxx
 	FuncType *FuncType
 	AddError bool
-	Const    string // constant definition
+	Const    string   // constant definition
+	Params   []string // parameters of a function-like macro
 }
 
 // IsVar reports whether Kind is either "var" or "fpvar"
@@ -116,6 +183,32 @@ type Type struct {
 	Go         ast.Expr
 	EnumValues map[string]int64
 	Typedef    string
+	Fields     []*StructField // layout of C struct and union types
+	Union      bool           // Fields overlay one another, as in a C union
+	Packed     bool           // some Fields are misaligned for their type, as in a packed struct
+}
+
+// A StructField describes a field of a C struct or union, as laid out by gcc.
+type StructField struct {
+	Name      string // C field name; "" for an anonymous struct or union member
+	Offset    int64  // byte offset within the struct; for a bit-field, of its storage unit
+	Type      *Type  // for an anonymous struct or union, its layout, with Fields
+	BitOffset int64  // for a bit-field, offset of its lowest bit within the storage unit's value
+	BitSize   int64  // for a bit-field, its width in bits; otherwise 0
+	Go        string // name of the Go field holding it, or "" if it has none
+}
+
+// An Enum describes a C enumeration type and all of its enumerators.
+type Enum struct {
+	Name        string // C name: "enum foo", or the typedef name of an anonymous enum
+	Type        *Type
+	Enumerators []*Enumerator // in declaration order
+}
+
+// An Enumerator is a named value of a C enumeration.
+type Enumerator struct {
+	Name  string
+	Value int64
 }
 
 // A FuncType collects information about a function type in both the C and Go worlds.
@@ -125,62 +218,49 @@ type FuncType struct {
 	Go     *ast.FuncType
 }
 
-func usage() {
+func usage(flags *flag.FlagSet) {
 	fmt.Fprint(os.Stderr, "usage: cgo -- [compiler options] file.go ...\n")
-	flag.PrintDefaults()
+	flags.PrintDefaults()
 	os.Exit(2)
 }
 
-var ptrSizeMap = map[string]int64{
-	"386":     4,
-	"amd64":   8,
-	"arm":     4,
-	"arm64":   8,
-	"ppc64":   8,
-	"ppc64le": 8,
-	"s390":    4,
-	"s390x":   8,
-}
-
-var intSizeMap = map[string]int64{
-	"386":     4,
-	"amd64":   8,
-	"arm":     4,
-	"arm64":   8,
-	"ppc64":   8,
-	"ppc64le": 8,
-	"s390":    4,
-	"s390x":   4,
-}
-
 var cPrefix string
 
 var fset = token.NewFileSet()
 
-var dynobj = flag.String("dynimport", "", "if non-empty, print dynamic import data for that file")
-var dynout = flag.String("dynout", "", "write -dynimport output to this file")
-var dynpackage = flag.String("dynpackage", "main", "set Go package for -dynimport output")
-var dynlinker = flag.Bool("dynlinker", false, "record dynamic linker information in -dynimport mode")
-
-// This flag is for bootstrapping a new Go implementation,
-// to generate Go types that match the data layout and
-// constant values used in the host's C libraries and system calls.
-var godefs = flag.Bool("godefs", false, "for bootstrap: write Go definitions for C file to standard output")
-
-var objDir = flag.String("objdir", "", "object directory")
-var importPath = flag.String("importpath", "", "import path of package being built (for comments in generated files)")
-var exportHeader = flag.String("exportheader", "", "where to write export header if any exported functions")
-
-var gccgo = flag.Bool("gccgo", false, "generate files for use with gccgo")
-var gccgoprefix = flag.String("gccgoprefix", "", "-fgo-prefix option used with gccgo")
-var gccgopkgpath = flag.String("gccgopkgpath", "", "-fgo-pkgpath option used with gccgo")
-var importRuntimeCgo = flag.Bool("import_runtime_cgo", true, "import runtime/cgo in generated code")
-var importSyscall = flag.Bool("import_syscall", true, "import syscall in generated code")
 var goarch, goos string
 
-func mainx() {
-	flag.Usage = usage
-	flag.Parse()
+// mainx is the cgo command, run with the command-line arguments args.
+func mainx(args []string) {
+	var opts Options
+	flags := flag.NewFlagSet("cgo", flag.ExitOnError)
+	flags.Usage = func() { usage(flags) }
+	dynobj := flags.String("dynimport", "", "if non-empty, print dynamic import data for that file")
+	dynout := flags.String("dynout", "", "write -dynimport output to this file")
+	dynpackage := flags.String("dynpackage", "main", "set Go package for -dynimport output")
+	dynlinker := flags.Bool("dynlinker", false, "record dynamic linker information in -dynimport mode")
+
+	// This flag is for bootstrapping a new Go implementation,
+	// to generate Go types that match the data layout and
+	// constant values used in the host's C libraries and system calls.
+	flags.BoolVar(&opts.Godefs, "godefs", false, "for bootstrap: write Go definitions for C file to standard output")
+
+	flags.StringVar(&opts.ObjDir, "objdir", "", "object directory")
+	flags.StringVar(&opts.ImportPath, "importpath", "", "import path of package being built (for comments in generated files)")
+	flags.StringVar(&opts.ExportHeader, "exportheader", "", "where to write export header if any exported functions")
+
+	flags.BoolVar(&opts.Gccgo, "gccgo", false, "generate files for use with gccgo")
+	flags.StringVar(&opts.GccgoPrefix, "gccgoprefix", "", "-fgo-prefix option used with gccgo")
+	flags.StringVar(&opts.GccgoPkgPath, "gccgopkgpath", "", "-fgo-pkgpath option used with gccgo")
+	importRuntimeCgo := flags.Bool("import_runtime_cgo", true, "import runtime/cgo in generated code")
+	importSyscall := flags.Bool("import_syscall", true, "import syscall in generated code")
+	flags.BoolVar(&opts.DebugDefine, "debug-define", false, "print relevant #defines")
+	flags.BoolVar(&opts.DebugGcc, "debug-gcc", false, "print gcc invocations")
+	flags.BoolVar(&opts.Reproducible, "reproducible", false, "make the output independent of where and how often it is generated")
+	flags.StringVar(&opts.TrimPath, "trimpath", "", "with -reproducible, remove these ;-separated prefixes from recorded file names")
+	flags.Parse(args)
+	opts.NoRuntimeCgo = !*importRuntimeCgo
+	opts.NoSyscall = !*importSyscall
 
 	if *dynobj != "" {
 		// cgo -dynimport is essentially a separate helper command
@@ -191,20 +271,13 @@ func mainx() {
 		// instead of needing to make the linkers duplicate all the
 		// specialized knowledge gcc has about where to look for imported
 		// symbols and which ones to use.
-		dynimport(*dynobj)
+		dynimport(*dynobj, *dynout, *dynpackage, *dynlinker)
 		return
 	}
 
-	if *godefs {
-		// Generating definitions pulled from header files,
-		// to be checked into Go repositories.
-		// Line numbers are just noise.
-		conf.Mode &^= printer.SourcePos
-	}
-
-	args := flag.Args()
+	args = flags.Args()
 	if len(args) < 1 {
-		usage()
+		usage(flags)
 	}
 
 	// Find first arg that looks like a go file and assume everything before
@@ -216,12 +289,19 @@ func mainx() {
 		}
 	}
 	if i == len(args) {
-		usage()
+		usage(flags)
 	}
 
 	goFiles := args[i:]
 
-	p := newPackage(args[:i])
+	if opts.ObjDir == "" && !opts.Godefs {
+		// make sure that _obj directory exists, so that we can write
+		// all the output files there.
+		os.Mkdir("_obj", 0777)
+		opts.ObjDir = "_obj"
+	}
+
+	p := newPackage(args[:i], opts)
 
 	// Record CGO_LDFLAGS from the environment for external linking.
 	if ldflags := os.Getenv("CGO_LDFLAGS"); ldflags != "" {
@@ -250,20 +330,11 @@ func mainx() {
 
 	fs := make([]*File, len(goFiles))
 	for i, input := range goFiles {
-		f := new(File)
-		f.ReadGo(input)
-		f.DiscardCgoDirectives()
+		f := p.ReadFile(input)
+		p.ParseFlags(f, input)
 		fs[i] = f
 	}
 
-	if *objDir == "" {
-		// make sure that _obj directory exists, so that we can write
-		// all the output files there.
-		os.Mkdir("_obj", 0777)
-		*objDir = "_obj"
-	}
-	*objDir += string(filepath.Separator)
-
 	for i, input := range goFiles {
 		f := fs[i]
 		p.Translate(f)
@@ -285,14 +356,14 @@ func mainx() {
 		}
 		p.PackagePath = pkg
 		p.Record(f)
-		if *godefs {
+		if p.Godefs {
 			os.Stdout.WriteString(p.godefs(f, input))
 		} else {
 			p.writeOutput(f, input)
 		}
 	}
 
-	if !*godefs {
+	if !p.Godefs {
 		p.writeDefs()
 	}
 	if nerrors > 0 {
@@ -300,35 +371,54 @@ func mainx() {
 	}
 }
 
+// NewPackage returns a new Package that will invoke
+// gcc with the additional arguments specified in args
+// and write its output as opts says.
+func NewPackage(args []string, opts Options) *Package {
+	return newPackage(args, opts)
+}
+
 // newPackage returns a new Package that will invoke
 // gcc with the additional arguments specified in args.
-func newPackage(args []string) *Package {
-	goarch = runtime.GOARCH
-	if s := os.Getenv("GOARCH"); s != "" {
-		goarch = s
-	}
-	goos = runtime.GOOS
-	if s := os.Getenv("GOOS"); s != "" {
-		goos = s
+func newPackage(args []string, opts Options) *Package {
+	goarch = opts.GOARCH
+	if goarch == "" {
+		goarch = runtime.GOARCH
+		if s := os.Getenv("GOARCH"); s != "" {
+			goarch = s
+		}
 	}
-	ptrSize := ptrSizeMap[goarch]
-	if ptrSize == 0 {
-		fatalf("unknown ptrSize for $GOARCH %q", goarch)
+	goos = opts.GOOS
+	if goos == "" {
+		goos = runtime.GOOS
+		if s := os.Getenv("GOOS"); s != "" {
+			goos = s
+		}
 	}
-	intSize := intSizeMap[goarch]
-	if intSize == 0 {
-		fatalf("unknown intSize for $GOARCH %q", goarch)
+	target := targets[goarch]
+	if target == nil {
+		fatalf("unknown target for $GOARCH %q", goarch)
 	}
 
 	// Reset locale variables so gcc emits English errors [sic].
 	os.Setenv("LANG", "en_US.UTF-8")
 	os.Setenv("LC_ALL", "C")
 
+	// The C types found so far were laid out for the previous
+	// package's target, which need not be this one's.
+	typedef = make(map[string]*Type)
+	enumDefs = make(map[string]*Enum)
+	enumOf = make(map[string]*Enum)
+	goIdent = make(map[string]*ast.Ident)
+	tagGen = 0
+
 	p := &Package{
-		PtrSize:  ptrSize,
-		IntSize:  intSize,
+		PtrSize:  target.PtrSize,
+		IntSize:  target.IntSize,
+		Target:   target,
 		CgoFlags: make(map[string][]string),
 		Written:  make(map[string]bool),
+		Options:  opts,
 	}
 	p.addToFlag("CFLAGS", args)
 	return p
@@ -6,50 +6,65 @@ package cgo
 
 import (
 	"bytes"
-	"debug/elf"
-	"debug/macho"
-	"debug/pe"
 	"fmt"
 	"go/ast"
 	"go/printer"
 	"go/token"
 	"io"
+	"io/ioutil"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 )
 
 var conf = printer.Config{Mode: printer.SourcePos, Tabwidth: 8}
 
+// WriteDefs creates the output files shared by all the Go files
+// of the package, such as _cgo_gotypes.go.
+func (p *Package) WriteDefs() {
+	p.writeDefs()
+	if nerrors > 0 {
+		os.Exit(2)
+	}
+}
+
 // writeDefs creates output files to be compiled by gc and gcc.
 func (p *Package) writeDefs() {
 	var fgo2, fc io.Writer
-	f := creat(*objDir + "_cgo_gotypes.go")
-	defer f.Close()
+	f := p.create("_cgo_gotypes.go")
 	fgo2 = f
-	if *gccgo {
-		f := creat(*objDir + "_cgo_defun.c")
-		defer f.Close()
-		fc = f
+	var fdefun io.WriteCloser
+	if p.Gccgo {
+		fdefun = p.create("_cgo_defun.c")
+		fc = fdefun
 	}
-	fm := creat(*objDir + "_cgo_main.c")
+	fm := p.create("_cgo_main.c")
 
 	var gccgoInit bytes.Buffer
 
-	fflg := creat(*objDir + "_cgo_flags")
-	for k, v := range p.CgoFlags {
+	fflg := p.create("_cgo_flags")
+	flagKeys := make([]string, 0, len(p.CgoFlags))
+	for k := range p.CgoFlags {
+		flagKeys = append(flagKeys, k)
+	}
+	sort.Strings(flagKeys)
+	for _, k := range flagKeys {
+		v := p.CgoFlags[k]
 		fmt.Fprintf(fflg, "_CGO_%s=%s\n", k, strings.Join(v, " "))
-		if k == "LDFLAGS" && !*gccgo {
+		if k == "LDFLAGS" && !p.Gccgo {
 			for _, arg := range v {
 				fmt.Fprintf(fgo2, "//go:cgo_ldflag %q\n", arg)
 			}
 		}
 	}
-	fflg.Close()
+	if err := fflg.Close(); err != nil {
+		fatalf("%s", err)
+	}
 
 	// Write C main file for using gcc to resolve imports.
 	fmt.Fprintf(fm, "int main() { return 0; }\n")
-	if *importRuntimeCgo {
+	if !p.NoRuntimeCgo {
 		fmt.Fprintf(fm, "void crosscall2(void(*fn)(void*, int), void *a, int c) { }\n")
 		fmt.Fprintf(fm, "void _cgo_wait_runtime_init_done() { }\n")
 		fmt.Fprintf(fm, "char* _cgo_topofstack(void) { return (char*)0; }\n")
@@ -69,16 +84,16 @@ func (p *Package) writeDefs() {
 	fmt.Fprintf(fgo2, "// Created by cgo - DO NOT EDIT\n\n")
 	fmt.Fprintf(fgo2, "package %s\n\n", p.PackageName)
 	fmt.Fprintf(fgo2, "import \"unsafe\"\n\n")
-	if !*gccgo && *importRuntimeCgo {
+	if !p.Gccgo && !p.NoRuntimeCgo {
 		fmt.Fprintf(fgo2, "import _ \"runtime/cgo\"\n\n")
 	}
-	if *importSyscall {
+	if !p.NoSyscall {
 		fmt.Fprintf(fgo2, "import \"syscall\"\n\n")
 		fmt.Fprintf(fgo2, "var _ syscall.Errno\n")
 	}
 	fmt.Fprintf(fgo2, "func _Cgo_ptr(ptr unsafe.Pointer) unsafe.Pointer { return ptr }\n\n")
 
-	if !*gccgo {
+	if !p.Gccgo {
 		fmt.Fprintf(fgo2, "//go:linkname _Cgo_always_false runtime.cgoAlwaysFalse\n")
 		fmt.Fprintf(fgo2, "var _Cgo_always_false bool\n")
 		fmt.Fprintf(fgo2, "//go:linkname _Cgo_use runtime.cgoUse\n")
@@ -96,13 +111,16 @@ func (p *Package) writeDefs() {
 		conf.Fprint(fgo2, fset, def.Go)
 		fmt.Fprintf(fgo2, "\n\n")
 	}
-	if *gccgo {
+	for _, name := range typedefNames {
+		writeAccessors(fgo2, name, typedef[name])
+	}
+	if p.Gccgo {
 		fmt.Fprintf(fgo2, "type _Ctype_void byte\n")
 	} else {
 		fmt.Fprintf(fgo2, "type _Ctype_void [0]byte\n")
 	}
 
-	if *gccgo {
+	if p.Gccgo {
 		fmt.Fprint(fc, p.cPrologGccgo())
 	} else {
 		fmt.Fprint(fgo2, goProlog)
@@ -118,7 +136,7 @@ func (p *Package) writeDefs() {
 		}
 
 		if !cVars[n.C] {
-			if *gccgo {
+			if p.Gccgo {
 				fmt.Fprintf(fc, "extern byte *%s;\n", n.C)
 			} else {
 				fmt.Fprintf(fm, "extern char %s[];\n", n.C)
@@ -138,7 +156,7 @@ func (p *Package) writeDefs() {
 		} else {
 			panic(fmt.Errorf("invalid var kind %q", n.Kind))
 		}
-		if *gccgo {
+		if p.Gccgo {
 			fmt.Fprintf(fc, `extern void *%s __asm__("%s.%s");`, n.Mangle, gccgoSymbolPrefix, n.Mangle)
 			fmt.Fprintf(&gccgoInit, "\t%s = &%s;\n", n.Mangle, n.C)
 			fmt.Fprintf(fc, "\n")
@@ -146,14 +164,14 @@ func (p *Package) writeDefs() {
 
 		fmt.Fprintf(fgo2, "var %s ", n.Mangle)
 		conf.Fprint(fgo2, fset, node)
-		if !*gccgo {
+		if !p.Gccgo {
 			fmt.Fprintf(fgo2, " = (")
 			conf.Fprint(fgo2, fset, node)
 			fmt.Fprintf(fgo2, ")(unsafe.Pointer(&__cgo_%s))", n.C)
 		}
 		fmt.Fprintf(fgo2, "\n")
 	}
-	if *gccgo {
+	if p.Gccgo {
 		fmt.Fprintf(fc, "\n")
 	}
 
@@ -165,6 +183,10 @@ func (p *Package) writeDefs() {
 	}
 	fmt.Fprintf(fgo2, "\n")
 
+	if p.EnumConsts {
+		p.writeEnumConsts(fgo2)
+	}
+
 	for _, key := range nameKeys(p.Name) {
 		n := p.Name[key]
 		if n.FuncType != nil {
@@ -172,9 +194,11 @@ func (p *Package) writeDefs() {
 		}
 	}
 
-	fgcc := creat(*objDir + "_cgo_export.c")
-	fgcch := creat(*objDir + "_cgo_export.h")
-	if *gccgo {
+	fgcc := p.create("_cgo_export.c")
+	fexp := p.create("_cgo_export.h")
+	var hdr bytes.Buffer
+	fgcch := io.MultiWriter(fexp, &hdr)
+	if p.Gccgo {
 		p.writeGccgoExports(fgo2, fm, fgcc, fgcch)
 	} else {
 		p.writeExports(fgo2, fm, fgcc, fgcch)
@@ -182,21 +206,12 @@ func (p *Package) writeDefs() {
 	if err := fgcc.Close(); err != nil {
 		fatalf("%s", err)
 	}
-	if err := fgcch.Close(); err != nil {
+	if err := fexp.Close(); err != nil {
 		fatalf("%s", err)
 	}
 
-	if *exportHeader != "" && len(p.ExpFunc) > 0 {
-		fexp := creat(*exportHeader)
-		fgcch, err := os.Open(*objDir + "_cgo_export.h")
-		if err != nil {
-			fatalf("%s", err)
-		}
-		_, err = io.Copy(fexp, fgcch)
-		if err != nil {
-			fatalf("%s", err)
-		}
-		if err = fexp.Close(); err != nil {
+	if p.ExportHeader != "" && len(p.ExpFunc) > 0 {
+		if err := ioutil.WriteFile(p.ExportHeader, hdr.Bytes(), 0666); err != nil {
 			fatalf("%s", err)
 		}
 	}
@@ -208,86 +223,72 @@ func (p *Package) writeDefs() {
 		fmt.Fprint(fc, init)
 		fmt.Fprintln(fc, "}")
 	}
-}
 
-func dynimport(obj string) {
-	stdout := os.Stdout
-	if *dynout != "" {
-		f, err := os.Create(*dynout)
-		if err != nil {
+	if err := f.Close(); err != nil {
+		fatalf("%s", err)
+	}
+	if fdefun != nil {
+		if err := fdefun.Close(); err != nil {
 			fatalf("%s", err)
 		}
-		stdout = f
 	}
+	if err := fm.Close(); err != nil {
+		fatalf("%s", err)
+	}
+}
 
-	fmt.Fprintf(stdout, "package %s\n", *dynpackage)
-
-	if f, err := elf.Open(obj); err == nil {
-		if *dynlinker {
-			// Emit the cgo_dynamic_linker line.
-			if sec := f.Section(".interp"); sec != nil {
-				if data, err := sec.Data(); err == nil && len(data) > 1 {
-					// skip trailing \0 in data
-					fmt.Fprintf(stdout, "//go:cgo_dynamic_linker %q\n", string(data[:len(data)-1]))
-				}
-			}
-		}
-		sym, err := f.ImportedSymbols()
-		if err != nil {
-			fatalf("cannot load imported symbols from ELF file %s: %v", obj, err)
-		}
-		for _, s := range sym {
-			targ := s.Name
-			if s.Version != "" {
-				targ += "#" + s.Version
-			}
-			fmt.Fprintf(stdout, "//go:cgo_import_dynamic %s %s %q\n", s.Name, targ, s.Library)
-		}
-		lib, err := f.ImportedLibraries()
-		if err != nil {
-			fatalf("cannot load imported libraries from ELF file %s: %v", obj, err)
+// writeEnumConsts writes a constant block for every C enum.
+// The constants are named _Cenum_ and the enumerator, so that
+// they do not collide with the _Cconst_ names of C.xxx references.
+func (p *Package) writeEnumConsts(fgo2 io.Writer) {
+	for _, e := range Enums() {
+		if len(e.Enumerators) == 0 {
+			continue
 		}
-		for _, l := range lib {
-			fmt.Fprintf(stdout, "//go:cgo_import_dynamic _ _ %q\n", l)
+		fmt.Fprintf(fgo2, "// %s\n", e.Name)
+		fmt.Fprintf(fgo2, "const (\n")
+		for _, ev := range e.Enumerators {
+			fmt.Fprintf(fgo2, "\t_Cenum_%s = %#x\n", ev.Name, ev.Value)
 		}
-		return
+		fmt.Fprintf(fgo2, ")\n\n")
 	}
+}
 
-	if f, err := macho.Open(obj); err == nil {
-		sym, err := f.ImportedSymbols()
-		if err != nil {
-			fatalf("cannot load imported symbols from Mach-O file %s: %v", obj, err)
-		}
-		for _, s := range sym {
-			if len(s) > 0 && s[0] == '_' {
-				s = s[1:]
-			}
-			fmt.Fprintf(stdout, "//go:cgo_import_dynamic %s %s %q\n", s, s, "")
-		}
-		lib, err := f.ImportedLibraries()
-		if err != nil {
-			fatalf("cannot load imported libraries from Mach-O file %s: %v", obj, err)
-		}
-		for _, l := range lib {
-			fmt.Fprintf(stdout, "//go:cgo_import_dynamic _ _ %q\n", l)
-		}
-		return
+// dynimport writes to out, or to standard output if out is "",
+// the Go package pkg holding the dynamic import data for the
+// executable obj.  If linker is set, it records the ELF dynamic
+// linker too.
+func dynimport(obj, out, pkg string, linker bool) {
+	info, err := DynamicImports(obj)
+	if err != nil {
+		fatalf("%s", err)
 	}
 
-	if f, err := pe.Open(obj); err == nil {
-		sym, err := f.ImportedSymbols()
+	stdout := os.Stdout
+	if out != "" {
+		f, err := os.Create(out)
 		if err != nil {
-			fatalf("cannot load imported symbols from PE file %s: %v", obj, err)
-		}
-		for _, s := range sym {
-			ss := strings.Split(s, ":")
-			name := strings.Split(ss[0], "@")[0]
-			fmt.Fprintf(stdout, "//go:cgo_import_dynamic %s %s %q\n", name, ss[0], strings.ToLower(ss[1]))
+			fatalf("%s", err)
 		}
-		return
+		stdout = f
 	}
 
-	fatalf("cannot parse %s as ELF, Mach-O or PE", obj)
+	fmt.Fprintf(stdout, "package %s\n", pkg)
+
+	if linker && info.Linker != "" {
+		// Emit the cgo_dynamic_linker line.
+		fmt.Fprintf(stdout, "//go:cgo_dynamic_linker %q\n", info.Linker)
+	}
+	for _, s := range info.Symbols {
+		fmt.Fprintf(stdout, "//go:cgo_import_dynamic %s %s %q\n", s.Name, s.Target, s.Library)
+	}
+	if info.Format == "pe" {
+		// The symbols name their libraries.
+		return
+	}
+	for _, l := range info.Libraries {
+		fmt.Fprintf(stdout, "//go:cgo_import_dynamic _ _ %q\n", l)
+	}
 }
 
 // Construct a gcc struct matching the gc argument frame.
@@ -342,6 +343,93 @@ func (p *Package) structType(n *Name) (string, int64) {
 	return buf.String(), off
 }
 
+// writeAccessors writes functions giving access to the members
+// of the C struct or union t that have no Go field: the members
+// of a union and the bit-fields that do not fill whole, aligned
+// bytes.  A bit-field of a packed struct that no storage unit of
+// its type's size holds gets none.  Go types from C cannot have
+// methods, so for a member f of the Go type name, _Ctype_T or T,
+// the functions are _Cunion_T_f for a union member, returning a
+// pointer to it, and _Cbitfield_get_T_f and _Cbitfield_set_T_f
+// for a bit-field.  A typedef of a struct or union gets its own.
+func writeAccessors(fgo2 io.Writer, name string, t *Type) {
+	t = underlyingStruct(t)
+	tname := strings.TrimPrefix(name, "_Ctype_")
+	for _, f := range t.Fields {
+		if f.Go != "" || f.Name == "" {
+			continue
+		}
+		typ := gofmt(f.Type.Go)
+		if f.BitSize == 0 {
+			// Only union members get here; they all start at offset 0.
+			fmt.Fprintf(fgo2, "func _Cunion_%s_%s(p *%s) *%s { return (*%s)(unsafe.Pointer(p)) }\n\n", tname, f.Name, name, typ, typ)
+			continue
+		}
+		kind := basicGo(f.Type)
+		bits := f.Type.Size * 8
+		if kind == "" || bits > 64 || f.BitOffset+f.BitSize > bits {
+			continue
+		}
+		unit := fmt.Sprintf("(*uint%d)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) + %d))", bits, f.Offset)
+		mask := uint64(1)<<uint(f.BitSize) - 1
+		fmt.Fprintf(fgo2, "func _Cbitfield_get_%s_%s(p *%s) %s {\n", tname, f.Name, name, typ)
+		switch {
+		case kind == "bool":
+			fmt.Fprintf(fgo2, "\treturn *%s>>%d&%#x != 0\n", unit, f.BitOffset, mask)
+		case strings.HasPrefix(kind, "int"):
+			// Shift the field to the top of the unit and back,
+			// to extend its sign.
+			fmt.Fprintf(fgo2, "\treturn %s(int%d(*%s<<%d) >> %d)\n", typ, bits, unit, bits-f.BitOffset-f.BitSize, bits-f.BitSize)
+		default:
+			fmt.Fprintf(fgo2, "\treturn %s(*%s >> %d & %#x)\n", typ, unit, f.BitOffset, mask)
+		}
+		fmt.Fprintf(fgo2, "}\n\n")
+		fmt.Fprintf(fgo2, "func _Cbitfield_set_%s_%s(p *%s, v %s) {\n", tname, f.Name, name, typ)
+		fmt.Fprintf(fgo2, "\tu := %s\n", unit)
+		if kind == "bool" {
+			fmt.Fprintf(fgo2, "\tb := uint%d(0)\n\tif v {\n\t\tb = 1\n\t}\n", bits)
+			fmt.Fprintf(fgo2, "\t*u = *u&^(%#x<<%d) | b<<%d\n", mask, f.BitOffset, f.BitOffset)
+		} else {
+			fmt.Fprintf(fgo2, "\t*u = *u&^(%#x<<%d) | uint%d(v)&%#x<<%d\n", mask, f.BitOffset, bits, mask, f.BitOffset)
+		}
+		fmt.Fprintf(fgo2, "}\n\n")
+	}
+}
+
+// underlyingStruct returns the struct or union type that
+// the typedef t names, following typedefs, or t itself.
+func underlyingStruct(t *Type) *Type {
+	for t.Fields == nil {
+		id, ok := t.Go.(*ast.Ident)
+		if !ok || typedef[id.Name] == nil || typedef[id.Name] == t {
+			return t
+		}
+		t = typedef[id.Name]
+	}
+	return t
+}
+
+// basicGo returns the predeclared Go type underlying t, following
+// typedefs, or "" if it is not an integer or boolean type.
+func basicGo(t *Type) string {
+	x := t.Go
+	for {
+		id, ok := x.(*ast.Ident)
+		if !ok {
+			return ""
+		}
+		def := typedef[id.Name]
+		if def == nil {
+			switch id.Name {
+			case "bool", "int8", "int16", "int32", "int64", "uint8", "uint16", "uint32", "uint64", "uintptr":
+				return id.Name
+			}
+			return ""
+		}
+		x = def.Go
+	}
+}
+
 func (p *Package) writeDefsFunc(fgo2 io.Writer, n *Name) {
 	name := n.Go
 	gtype := n.FuncType.Go
@@ -378,7 +466,7 @@ func (p *Package) writeDefsFunc(fgo2 io.Writer, n *Name) {
 		paramnames = append(paramnames, paramName)
 	}
 
-	if *gccgo {
+	if p.Gccgo {
 		// Gccgo style hooks.
 		fmt.Fprint(fgo2, "\n")
 		conf.Fprint(fgo2, fset, d)
@@ -485,12 +573,21 @@ func (p *Package) WriteOutput(f *File, srcfile string) {
 // writeOutput creates stubs for a specific source file to be compiled by gc
 func (p *Package) writeOutput(f *File, srcfile string) {
 	base := srcfile
+	if p.Reproducible {
+		// Name the files after the trimmed path, but a file
+		// outside every trimmed directory keeps the name it
+		// was given rather than its absolute path.
+		abs := absPath(srcfile)
+		if trimmed := p.trimPath(abs); trimmed != abs {
+			base = trimmed
+		}
+	}
 	if strings.HasSuffix(base, ".go") {
 		base = base[0 : len(base)-3]
 	}
 	base = strings.Map(slashToUnderscore, base)
-	fgo1 := creat(*objDir + base + ".cgo1.go")
-	fgcc := creat(*objDir + base + ".cgo2.c")
+	fgo1 := p.create(base + ".cgo1.go")
+	fgcc := p.create(base + ".cgo2.c")
 
 	p.GoFiles = append(p.GoFiles, base+".cgo1.go")
 	p.GccFiles = append(p.GccFiles, base+".cgo2.c")
@@ -511,8 +608,12 @@ func (p *Package) writeOutput(f *File, srcfile string) {
 		}
 	}
 
-	fgo1.Close()
-	fgcc.Close()
+	if err := fgo1.Close(); err != nil {
+		fatalf("%s", err)
+	}
+	if err := fgcc.Close(); err != nil {
+		fatalf("%s", err)
+	}
 }
 
 // fixGo converts the internal Name.Go field into the name we should show
@@ -525,6 +626,13 @@ func fixGo(name string) string {
 	return name
 }
 
+// IsBuiltin reports whether C.name is one of the helper functions
+// that cgo defines itself, such as C.CString, rather than a function
+// of the C library.
+func IsBuiltin(name string) bool {
+	return builtinDefs[name] != ""
+}
+
 var isBuiltin = map[string]bool{
 	"_Cfunc_CString":   true,
 	"_Cfunc_GoString":  true,
@@ -533,7 +641,7 @@ var isBuiltin = map[string]bool{
 	"_Cfunc__CMalloc":  true,
 }
 
-func (p *Package) writeOutputFunc(fgcc *os.File, n *Name) {
+func (p *Package) writeOutputFunc(fgcc io.Writer, n *Name) {
 	name := n.Mangle
 	if isBuiltin[name] || p.Written[name] {
 		// The builtins are already defined in the C prolog, and we don't
@@ -542,7 +650,7 @@ func (p *Package) writeOutputFunc(fgcc *os.File, n *Name) {
 	}
 	p.Written[name] = true
 
-	if *gccgo {
+	if p.Gccgo {
 		p.writeGccgoOutputFunc(fgcc, n)
 		return
 	}
@@ -613,7 +721,7 @@ func (p *Package) writeOutputFunc(fgcc *os.File, n *Name) {
 // wrapper to support static functions in the prologue--without a
 // wrapper, we can't refer to the function, since the reference is in
 // a different file.
-func (p *Package) writeGccgoOutputFunc(fgcc *os.File, n *Name) {
+func (p *Package) writeGccgoOutputFunc(fgcc io.Writer, n *Name) {
 	if t := n.FuncType.Result; t != nil {
 		fmt.Fprintf(fgcc, "%s\n", t.C.String())
 	} else {
@@ -1033,7 +1141,7 @@ func (p *Package) writeGccgoExports(fgo2, fm, fgcc, fgcch io.Writer) {
 // writeExportHeader writes out the start of the _cgo_export.h file.
 func (p *Package) writeExportHeader(fgcch io.Writer) {
 	fmt.Fprintf(fgcch, "/* Created by \"go tool cgo\" - DO NOT EDIT. */\n\n")
-	pkg := *importPath
+	pkg := p.ImportPath
 	if pkg == "" {
 		pkg = p.PackagePath
 	}
@@ -1044,11 +1152,12 @@ func (p *Package) writeExportHeader(fgcch io.Writer) {
 	fmt.Fprintf(fgcch, "\n/* End of preamble from import \"C\" comments.  */\n\n")
 
 	fmt.Fprintf(fgcch, "%s\n", p.gccExportHeaderProlog())
+	p.writeExportStructs(fgcch)
 }
 
 // Return the package prefix when using gccgo.
 func (p *Package) gccgoSymbolPrefix() string {
-	if !*gccgo {
+	if !p.Gccgo {
 		return ""
 	}
 
@@ -1061,13 +1170,13 @@ func (p *Package) gccgoSymbolPrefix() string {
 		return '_'
 	}
 
-	if *gccgopkgpath != "" {
-		return strings.Map(clean, *gccgopkgpath)
+	if p.GccgoPkgPath != "" {
+		return strings.Map(clean, p.GccgoPkgPath)
 	}
-	if *gccgoprefix == "" && p.PackageName == "main" {
+	if p.GccgoPrefix == "" && p.PackageName == "main" {
 		return "main"
 	}
-	prefix := strings.Map(clean, *gccgoprefix)
+	prefix := strings.Map(clean, p.GccgoPrefix)
 	if prefix == "" {
 		prefix = "go"
 	}
@@ -1131,7 +1240,7 @@ func (p *Package) cgoType(e ast.Expr) *Type {
 			return &Type{Size: p.PtrSize * 3, Align: p.PtrSize, C: c("GoSlice")}
 		}
 	case *ast.StructType:
-		// TODO
+		return p.cgoStruct("", t)
 	case *ast.FuncType:
 		return &Type{Size: p.PtrSize, Align: p.PtrSize, C: c("void*")}
 	case *ast.InterfaceType:
@@ -1154,6 +1263,9 @@ func (p *Package) cgoType(e ast.Expr) *Type {
 					continue
 				}
 				if ts.Name.Name == t.Name {
+					if st, ok := ts.Type.(*ast.StructType); ok {
+						return p.cgoStruct(t.Name, st)
+					}
 					return p.cgoType(ts.Type)
 				}
 			}
@@ -1196,6 +1308,198 @@ func (p *Package) cgoType(e ast.Expr) *Type {
 	return &Type{Size: 4, Align: 4, C: c("int")}
 }
 
+// An exportStruct is the C definition of a Go struct type
+// used by an exported function.
+type exportStruct struct {
+	Name   string // C struct tag and typedef name
+	Fields string // C member declarations
+	Type   *Type
+}
+
+// cgoStruct returns the Type of the Go struct type st, which is
+// named name or, if name is "", anonymous.  It records the C
+// definition of st for writeExportStructs, laying it out as gc
+// does, with explicit padding.
+func (p *Package) cgoStruct(name string, st *ast.StructType) *Type {
+	key := name
+	if key == "" {
+		key = gofmt(st)
+	}
+	if s := p.expStructs[key]; s != nil {
+		return s.Type
+	}
+	if p.expStructs == nil {
+		p.expStructs = make(map[string]*exportStruct)
+	}
+	cname := name
+	if cname == "" {
+		n := 0
+		for k, s := range p.expStructs {
+			if k != s.Name {
+				n++
+			}
+		}
+		cname = fmt.Sprintf("_GoStruct%d", n)
+	}
+	s := &exportStruct{Name: cname, Type: &Type{C: c(cname)}}
+	p.expStructs[key] = s // publish before recursive calls, for pointers to st
+
+	var buf bytes.Buffer
+	off, align, npad := int64(0), int64(1), 0
+	pad := func(n int64) {
+		fmt.Fprintf(&buf, "\tchar _pad%d[%d];\n", npad, n)
+		npad++
+		off += n
+	}
+	zero := false
+	for _, f := range st.Fields.List {
+		t := p.cgoFieldType(f.Type)
+		names := make([]string, len(f.Names))
+		for i, id := range f.Names {
+			names[i] = id.Name
+		}
+		if len(names) == 0 {
+			names = []string{embeddedName(f.Type)}
+		}
+		for _, n := range names {
+			if t.Align > 0 && off%t.Align != 0 {
+				pad(t.Align - off%t.Align)
+			}
+			switch {
+			case n == "_":
+				n = fmt.Sprintf("_pad%d", npad)
+				npad++
+			case cKeywords[n]:
+				n = "_" + n
+			}
+			fmt.Fprintf(&buf, "\t%s %s;\n", t.C, n)
+			off += t.Size
+			if t.Align > align {
+				align = t.Align
+			}
+			zero = t.Size == 0
+		}
+	}
+	end := off
+	if zero && off > 0 {
+		// gc pads a struct ending in a zero-size field, so that
+		// taking its address does not point past the struct.
+		end++
+	}
+	if end%align != 0 {
+		end += align - end%align
+	}
+	if end > off {
+		pad(end - off)
+	}
+	s.Fields = buf.String()
+	s.Type.Size = off
+	s.Type.Align = align
+	p.expStructList = append(p.expStructList, s)
+	return s.Type
+}
+
+// cgoFieldType is like cgoType but also accepts Go array types,
+// which C can hold in a struct but cannot pass or return by value.
+func (p *Package) cgoFieldType(e ast.Expr) *Type {
+	if t, ok := e.(*ast.ArrayType); ok && t.Len != nil {
+		if n := p.arrayLen(t.Len); n >= 0 {
+			elt := p.cgoFieldType(t.Elt)
+			return &Type{Size: n * elt.Size, Align: elt.Align, C: c("__typeof__(%s[%d])", elt.C, n)}
+		}
+	}
+	return p.cgoType(e)
+}
+
+// embeddedName returns the field name of an embedded field of type t.
+func embeddedName(t ast.Expr) string {
+	switch t := t.(type) {
+	case *ast.StarExpr:
+		return embeddedName(t.X)
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	case *ast.Ident:
+		return t.Name
+	}
+	return "_"
+}
+
+// arrayLen returns the length of a Go array type: an integer
+// literal or a top-level constant declared as one.  It returns -1
+// for any other length.
+func (p *Package) arrayLen(e ast.Expr) int64 {
+	switch x := e.(type) {
+	case *ast.BasicLit:
+		if x.Kind == token.INT {
+			if n, err := strconv.ParseInt(x.Value, 0, 64); err == nil {
+				return n
+			}
+		}
+	case *ast.ParenExpr:
+		return p.arrayLen(x.X)
+	case *ast.Ident:
+		for _, d := range p.Decl {
+			gd, ok := d.(*ast.GenDecl)
+			if !ok || gd.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				vs, ok := spec.(*ast.ValueSpec)
+				if !ok {
+					continue
+				}
+				for i, id := range vs.Names {
+					if id.Name == x.Name && i < len(vs.Values) {
+						return p.arrayLen(vs.Values[i])
+					}
+				}
+			}
+		}
+	}
+	return -1
+}
+
+// writeExportStructs writes to _cgo_export.h the C definitions of
+// the Go struct types used by exported functions, so that C code
+// can pass and receive them by value.
+func (p *Package) writeExportStructs(fgcch io.Writer) {
+	for _, exp := range p.ExpFunc {
+		fn := exp.Func
+		if fn.Recv != nil {
+			p.cgoType(fn.Recv.List[0].Type)
+		}
+		forFieldList(fn.Type.Params, func(i int, atype ast.Expr) { p.cgoType(atype) })
+		forFieldList(fn.Type.Results, func(i int, atype ast.Expr) { p.cgoType(atype) })
+	}
+	if len(p.expStructList) == 0 {
+		return
+	}
+
+	fmt.Fprintf(fgcch, "\n/* Go struct types used by exported functions.  */\n\n")
+	for _, s := range p.expStructList {
+		fmt.Fprintf(fgcch, "typedef struct %s %s;\n", s.Name, s.Name)
+	}
+	for _, s := range p.expStructList {
+		fmt.Fprintf(fgcch, "\nstruct %s {\n%s};\n", s.Name, s.Fields)
+		fmt.Fprintf(fgcch, "typedef char _check_for_%s_layout[sizeof(%s)==%d && __alignof__(%s)==%d ? 1:-1];\n", s.Name, s.Name, s.Type.Size, s.Name, s.Type.Align)
+	}
+}
+
+// cKeywords are the Go identifiers that cannot name a field
+// of a C struct, including in C++.
+var cKeywords = map[string]bool{
+	"auto": true, "bool": true, "catch": true, "char": true, "class": true,
+	"delete": true, "do": true, "double": true, "enum": true, "explicit": true,
+	"extern": true, "false": true, "float": true, "friend": true, "inline": true,
+	"int": true, "long": true, "mutable": true, "namespace": true, "new": true,
+	"operator": true, "private": true, "protected": true, "public": true,
+	"register": true, "restrict": true, "short": true, "signed": true,
+	"sizeof": true, "static": true, "template": true, "this": true,
+	"throw": true, "true": true, "try": true, "typedef": true, "typename": true,
+	"union": true, "unsigned": true, "using": true, "virtual": true,
+	"void": true, "volatile": true, "while": true,
+}
+
 const gccProlog = `
 // Usual nonsense: if x and y are not equal, the type will be invalid
 // (have a negative array count) and an inscrutable error will come
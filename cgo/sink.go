@@ -0,0 +1,121 @@
+// Destinations for the files that a Package writes.
+
+package cgo
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// An OutputSink receives the output files of a Package,
+// such as _cgo_gotypes.go and the .cgo1.go and .cgo2.c files.
+type OutputSink interface {
+	// Create returns a writer for the output file name.
+	// The file is complete once the writer is closed.
+	Create(name string) (io.WriteCloser, error)
+}
+
+// A DirSink writes output files to the directory it names.
+// The empty DirSink writes to the current directory.
+type DirSink string
+
+// Create creates the file name in the directory d.
+func (d DirSink) Create(name string) (io.WriteCloser, error) {
+	return os.Create(filepath.Join(string(d), name))
+}
+
+// A MemSink keeps output files in memory.
+// The zero MemSink is empty and ready to use.
+type MemSink struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+// Create returns a writer that stores the file name in m when closed,
+// replacing any earlier file of that name.
+func (m *MemSink) Create(name string) (io.WriteCloser, error) {
+	return &sinkFile{name: name, done: m.store}, nil
+}
+
+func (m *MemSink) store(name string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.files == nil {
+		m.files = make(map[string][]byte)
+	}
+	m.files[name] = data
+	return nil
+}
+
+// Names returns the sorted names of the files in m.
+func (m *MemSink) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.files))
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// File returns the contents of the file name in m and whether it exists.
+func (m *MemSink) File(name string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[name]
+	return data, ok
+}
+
+// A ZipSink writes output files into a zip archive.
+// Its Close method must be called to finish the archive.
+type ZipSink struct {
+	mu sync.Mutex
+	zw *zip.Writer
+}
+
+// NewZipSink returns a ZipSink writing an archive to w.
+func NewZipSink(w io.Writer) *ZipSink {
+	return &ZipSink{zw: zip.NewWriter(w)}
+}
+
+// Create returns a writer that adds the file name to the archive
+// when closed.  Files are buffered in memory until then, since a
+// Package writes several files at once.
+func (z *ZipSink) Create(name string) (io.WriteCloser, error) {
+	return &sinkFile{name: name, done: z.store}, nil
+}
+
+func (z *ZipSink) store(name string, data []byte) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	w, err := z.zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Close finishes the archive.  It does not close the underlying writer.
+func (z *ZipSink) Close() error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.zw.Close()
+}
+
+// A sinkFile buffers an output file and hands it to done when closed.
+type sinkFile struct {
+	bytes.Buffer
+	name string
+	done func(name string, data []byte) error
+}
+
+func (f *sinkFile) Close() error {
+	return f.done(f.name, f.Bytes())
+}
@@ -0,0 +1,101 @@
+package cgo
+
+import (
+	"archive/zip"
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// sinkSource calls a C function and exports a Go function,
+// so that a translation writes every kind of output file.
+const sinkSource = `package x
+
+/*
+static int twice(int x) { return 2 * x; }
+*/
+import "C"
+
+func F() int { return int(C.twice(2)) }
+
+//export G
+func G() {}
+`
+
+// translateToSink translates sinkSource as rasta translate
+// does, with the output going to sink.
+func translateToSink(t *testing.T, sink OutputSink) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "x.go")
+	if err := os.WriteFile(file, []byte(sinkSource), 0666); err != nil {
+		t.Fatal(err)
+	}
+	p := NewPackage(nil, Options{Sink: sink, ObjDir: dir, Reproducible: true, TrimPath: dir})
+	f := p.ReadFile(file)
+	p.ParseFlags(f, file)
+	p.Translate(f)
+	p.PackagePath = f.Package
+	p.Record(f)
+	p.WriteOutput(f, file)
+	p.WriteDefs()
+}
+
+// TestMemSinkGolden checks that a translation into a MemSink
+// writes the files listed in testdata/memsink.golden.
+func TestMemSinkGolden(t *testing.T) {
+	needCC(t)
+	var sink MemSink
+	translateToSink(t, &sink)
+	got := strings.Join(sink.Names(), "\n") + "\n"
+
+	golden := filepath.Join("testdata", "memsink.golden")
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("MemSink files differ from %s\n--- got\n%s--- want\n%s", golden, got, want)
+	}
+	for _, name := range sink.Names() {
+		if data, _ := sink.File(name); len(data) == 0 && name != "_cgo_flags" {
+			t.Errorf("%s is empty", name)
+		}
+	}
+}
+
+// TestZipSink checks that a ZipSink archives the same files as a MemSink.
+func TestZipSink(t *testing.T) {
+	needCC(t)
+	var mem MemSink
+	translateToSink(t, &mem)
+
+	var buf bytes.Buffer
+	z := NewZipSink(&buf)
+	translateToSink(t, z)
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	if got, want := strings.Join(names, " "), strings.Join(mem.Names(), " "); got != want {
+		t.Errorf("ZipSink files = %s, MemSink files = %s", got, want)
+	}
+}
@@ -0,0 +1,180 @@
+// Data layouts and compiler flags of the architectures rasta translates for.
+
+package cgo
+
+import (
+	"debug/elf"
+	"sort"
+)
+
+// A Target describes how C lays out data on one architecture,
+// and how to ask the C compiler for that architecture.
+type Target struct {
+	Arch string // GOARCH
+
+	// PtrSize is the size of a C pointer.  It is that of a Go
+	// pointer too, except on wasm, whose C is the 32-bit wasm32.
+	PtrSize int64
+	IntSize int64 // size of a Go int
+
+	BigEndian    bool
+	UnsignedChar bool // plain char is unsigned
+
+	MaxAlign       int64 // largest alignment of a scalar type inside a struct
+	DefaultAlign   int64 // alignment given by __attribute__((aligned)), gcc's __BIGGEST_ALIGNMENT__
+	LongDoubleSize int64
+
+	Machine []string    // C compiler flags selecting the architecture
+	Triple  string      // architecture part of the clang target triple
+	ELF     elf.Machine // machine of its ELF object files, if any
+}
+
+var targets = map[string]*Target{
+	"386": {
+		PtrSize: 4, IntSize: 4,
+		MaxAlign: 4, DefaultAlign: 16, LongDoubleSize: 12,
+		Machine: []string{"-m32"}, Triple: "i386",
+		ELF: elf.EM_386,
+	},
+	"amd64": {
+		PtrSize: 8, IntSize: 8,
+		MaxAlign: 16, DefaultAlign: 16, LongDoubleSize: 16,
+		Machine: []string{"-m64"}, Triple: "x86_64",
+		ELF: elf.EM_X86_64,
+	},
+	"arm": {
+		PtrSize: 4, IntSize: 4, UnsignedChar: true,
+		MaxAlign: 8, DefaultAlign: 8, LongDoubleSize: 8,
+		Machine: []string{"-marm"}, // not thumb
+		Triple:  "armv7",
+		ELF:     elf.EM_ARM,
+	},
+	"arm64": {
+		PtrSize: 8, IntSize: 8, UnsignedChar: true,
+		MaxAlign: 16, DefaultAlign: 16, LongDoubleSize: 16,
+		Triple: "aarch64",
+		ELF:    elf.EM_AARCH64,
+	},
+	"loong64": {
+		PtrSize: 8, IntSize: 8,
+		MaxAlign: 16, DefaultAlign: 16, LongDoubleSize: 16,
+		Machine: []string{"-mabi=lp64d"}, Triple: "loongarch64",
+		ELF: elf.EM_LOONGARCH,
+	},
+	"mips": {
+		PtrSize: 4, IntSize: 4, BigEndian: true,
+		MaxAlign: 8, DefaultAlign: 8, LongDoubleSize: 8,
+		Machine: []string{"-mabi=32"}, Triple: "mips",
+		ELF: elf.EM_MIPS,
+	},
+	"mipsle": {
+		PtrSize: 4, IntSize: 4,
+		MaxAlign: 8, DefaultAlign: 8, LongDoubleSize: 8,
+		Machine: []string{"-mabi=32"}, Triple: "mipsel",
+		ELF: elf.EM_MIPS,
+	},
+	"mips64": {
+		PtrSize: 8, IntSize: 8, BigEndian: true,
+		MaxAlign: 16, DefaultAlign: 16, LongDoubleSize: 16,
+		Machine: []string{"-mabi=64"}, Triple: "mips64",
+		ELF: elf.EM_MIPS,
+	},
+	"mips64le": {
+		PtrSize: 8, IntSize: 8,
+		MaxAlign: 16, DefaultAlign: 16, LongDoubleSize: 16,
+		Machine: []string{"-mabi=64"}, Triple: "mips64el",
+		ELF: elf.EM_MIPS,
+	},
+	"ppc64": {
+		PtrSize: 8, IntSize: 8, BigEndian: true, UnsignedChar: true,
+		MaxAlign: 16, DefaultAlign: 16, LongDoubleSize: 16,
+		Machine: []string{"-m64"}, Triple: "powerpc64",
+		ELF: elf.EM_PPC64,
+	},
+	"ppc64le": {
+		PtrSize: 8, IntSize: 8, UnsignedChar: true,
+		MaxAlign: 16, DefaultAlign: 16, LongDoubleSize: 16,
+		Machine: []string{"-m64"}, Triple: "powerpc64le",
+		ELF: elf.EM_PPC64,
+	},
+	"riscv64": {
+		PtrSize: 8, IntSize: 8, UnsignedChar: true,
+		MaxAlign: 16, DefaultAlign: 16, LongDoubleSize: 16,
+		Machine: []string{"-march=rv64gc", "-mabi=lp64d"}, Triple: "riscv64",
+		ELF: elf.EM_RISCV,
+	},
+	"s390": {
+		PtrSize: 4, IntSize: 4, BigEndian: true, UnsignedChar: true,
+		MaxAlign: 8, DefaultAlign: 8, LongDoubleSize: 16,
+		Machine: []string{"-m31"}, Triple: "s390",
+		ELF: elf.EM_S390,
+	},
+	"s390x": {
+		PtrSize: 8, IntSize: 8, BigEndian: true, UnsignedChar: true,
+		MaxAlign: 8, DefaultAlign: 8, LongDoubleSize: 16,
+		Machine: []string{"-m64"}, Triple: "s390x",
+		ELF: elf.EM_S390,
+	},
+	"wasm": {
+		PtrSize: 4, IntSize: 8,
+		MaxAlign: 16, DefaultAlign: 16, LongDoubleSize: 16,
+		Triple: "wasm32",
+	},
+}
+
+func init() {
+	for arch, t := range targets {
+		t.Arch = arch
+	}
+}
+
+// LookupTarget returns the description of the architecture goarch,
+// or nil if rasta does not know it.
+func LookupTarget(goarch string) *Target {
+	return targets[goarch]
+}
+
+// TargetArchs returns the sorted names of the known architectures.
+func TargetArchs() []string {
+	archs := make([]string, 0, len(targets))
+	for arch := range targets {
+		archs = append(archs, arch)
+	}
+	sort.Strings(archs)
+	return archs
+}
+
+// ClangTriple returns the clang target triple for t on goos,
+// or "" if there is none.
+func (t *Target) ClangTriple(goos string) string {
+	switch goos {
+	case "linux", "android":
+		env := "gnu"
+		switch {
+		case goos == "android":
+			env = "android"
+		case t.Arch == "arm":
+			env = "gnueabihf"
+		case t.Arch == "mips64" || t.Arch == "mips64le":
+			env = "gnuabi64"
+		}
+		return t.Triple + "-linux-" + env
+	case "darwin", "ios":
+		if t.Arch == "arm64" {
+			return "arm64-apple-" + goos
+		}
+		return t.Triple + "-apple-" + goos
+	case "windows":
+		if t.Arch == "386" {
+			return "i686-w64-windows-gnu"
+		}
+		return t.Triple + "-w64-windows-gnu"
+	case "freebsd", "netbsd", "openbsd":
+		return t.Triple + "-unknown-" + goos
+	case "js":
+		return t.Triple + "-unknown-unknown"
+	case "wasip1":
+		return t.Triple + "-wasi"
+	}
+	return ""
+}
@@ -10,6 +10,8 @@ import (
 	"go/token"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 )
 
 // run runs the command argv, feeding in stdin on standard input.
@@ -55,6 +57,100 @@ func error_(pos token.Pos, msg string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, "\n")
 }
 
+// trimPath returns the file name as recorded in p's output.
+// In Reproducible mode, the longest prefix of name listed in
+// p.TrimPath, or else the working directory, is removed or replaced.
+// Otherwise name is returned unchanged.
+func (p *Package) trimPath(name string) string {
+	if !p.Reproducible {
+		return name
+	}
+	rules := p.TrimPath
+	if rules == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return name
+		}
+		rules = wd
+	}
+	best, repl := "", ""
+	for _, rule := range strings.Split(rules, ";") {
+		prefix, to := rule, ""
+		if i := strings.Index(rule, "=>"); i >= 0 {
+			prefix, to = rule[:i], rule[i+2:]
+		}
+		if prefix == "" || len(prefix) <= len(best) {
+			continue
+		}
+		if name == prefix || strings.HasPrefix(name, prefix) && os.IsPathSeparator(name[len(prefix)]) {
+			best, repl = prefix, to
+		}
+	}
+	if best == "" {
+		return name
+	}
+	rest := strings.TrimLeft(name[len(best):], string(filepath.Separator))
+	switch {
+	case repl == "" && rest == "":
+		return "."
+	case repl == "":
+		return rest
+	case rest == "":
+		return repl
+	}
+	return repl + string(filepath.Separator) + rest
+}
+
+// pathOptions are the compiler and linker options that take a file
+// or directory name, which may be joined to them as in -I/usr/include.
+var pathOptions = []string{"-I", "-L", "-F", "-isystem", "-iquote", "-idirafter", "-isysroot", "-include", "-imacros", "--sysroot="}
+
+// trimFlags returns the compiler or linker arguments args with the
+// file names in them trimmed by trimPath: the arguments that are not
+// options, the names joined to the options in pathOptions, and the
+// comma-separated arguments of -Wl, options.
+func (p *Package) trimFlags(args []string) []string {
+	if !p.Reproducible {
+		return args
+	}
+	trimmed := make([]string, len(args))
+NextArg:
+	for i, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			trimmed[i] = p.trimPath(arg)
+			continue
+		}
+		if strings.HasPrefix(arg, "-Wl,") {
+			parts := strings.Split(arg[len("-Wl,"):], ",")
+			for j, part := range parts {
+				parts[j] = p.trimPath(part)
+			}
+			trimmed[i] = "-Wl," + strings.Join(parts, ",")
+			continue
+		}
+		for _, opt := range pathOptions {
+			if strings.HasPrefix(arg, opt) && len(arg) > len(opt) {
+				trimmed[i] = opt + p.trimPath(arg[len(opt):])
+				continue NextArg
+			}
+		}
+		trimmed[i] = arg
+	}
+	return trimmed
+}
+
+// CommandLine returns the command line that is being run, for comments
+// in generated files.  In Reproducible mode, the program is named
+// without its directory and the file names in the arguments are
+// trimmed as by trimFlags.
+func (p *Package) CommandLine() string {
+	if !p.Reproducible {
+		return strings.Join(os.Args, " ")
+	}
+	args := append([]string{filepath.Base(os.Args[0])}, p.trimFlags(os.Args[1:])...)
+	return strings.Join(args, " ")
+}
+
 // isName reports whether s is a valid C identifier
 func isName(s string) bool {
 	for i, v := range s {
@@ -68,14 +164,6 @@ func isName(s string) bool {
 	return s != ""
 }
 
-func creat(name string) *os.File {
-	f, err := os.Create(name)
-	if err != nil {
-		fatalf("%s", err)
-	}
-	return f
-}
-
 func slashToUnderscore(c rune) rune {
 	if c == '/' || c == '\\' || c == ':' {
 		c = '_'
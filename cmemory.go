@@ -0,0 +1,137 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+
+	"github.com/abduld/rasta/cgo"
+)
+
+// With Generator.CMemory, the ways Go code moves memory across cgo are
+// translated to heads that say who owns the memory afterwards, with an
+// option "Ownership" -> owner:
+//
+//   - C.CString(s) is Rasta`CString[s, "Ownership" -> "Caller"]: the
+//     string is copied to memory from malloc, which the caller frees;
+//   - C.GoString(p), C.GoStringN(p, n) and C.GoBytes(p, n) are
+//     Rasta`GoString[p], Rasta`GoString[p, n] and Rasta`GoBytes[p, n],
+//     with "Ownership" -> "Copy": the result is a copy, and p is
+//     still the C side's;
+//   - C.free(unsafe.Pointer(p)) is Rasta`CFree[p];
+//   - (*[1 << 30]T)(unsafe.Pointer(p))[:n:n] and
+//     unsafe.Slice((*T)(unsafe.Pointer(p)), n) are
+//     Rasta`CArrayView[p, T, n, "Ownership" -> "Borrowed"]: the n
+//     elements of type T at p, which stay the C side's.
+//
+// The conversions to unsafe.Pointer of the pointers that these take
+// are dropped.
+
+// memoryCall returns the head, the arguments and the owner of the
+// memory of the Rasta function that call stands for, or "" for the
+// head if it is none of them.  The owner is "" for Rasta`CFree.
+func (this *Generator) memoryCall(call *ast.CallExpr) (head string, args []ast.Expr, owner string) {
+	if sel, ok := call.Fun.(*ast.SelectorExpr); ok && this.isC(sel.X) {
+		name := sel.Sel.Name
+		if cgo.IsBuiltin(name) {
+			switch name {
+			case "CString":
+				return "CString", call.Args, "Caller"
+			case "GoString", "GoStringN":
+				return "GoString", this.stripFirst(call.Args), "Copy"
+			case "GoBytes":
+				return "GoBytes", this.stripFirst(call.Args), "Copy"
+			}
+		}
+		if name == "free" && len(call.Args) == 1 {
+			return "CFree", []ast.Expr{this.stripUnsafePointer(call.Args[0])}, ""
+		}
+	}
+	if this.isPackageFunc(call.Fun, "unsafe", "Slice") && len(call.Args) == 2 {
+		if ptr, elt, ok := this.pointerConversion(call.Args[0]); ok {
+			return "CArrayView", []ast.Expr{ptr, elt, call.Args[1]}, "Borrowed"
+		}
+	}
+	return "", nil, ""
+}
+
+// memoryExpr returns Rasta`head[args..., "Ownership" -> owner],
+// without the option if owner is "".
+func (this *Generator) memoryExpr(pos token.Pos, head string, args []ast.Expr, owner string) MExpr {
+	var margs []MExpr
+	for _, arg := range args {
+		margs = append(margs, this.translate(arg))
+	}
+	if owner != "" {
+		margs = append(margs, newRule(&MExprString{Value: "Ownership"}, &MExprString{Value: owner}))
+	}
+	return newPosNormal(pos, "Rasta", head, margs...)
+}
+
+// arrayView returns the pointer, the element type and the length of
+// the view of C memory that the slice expression x makes, if it is
+// one of the form (*[N]T)(p)[:n] or (*[N]T)(p)[0:n:n].
+func (this *Generator) arrayView(x *ast.SliceExpr) (ptr, elt, n ast.Expr, ok bool) {
+	if x.High == nil || x.Low != nil && !isZero(x.Low) {
+		return nil, nil, nil, false
+	}
+	ptr, t, ok := this.pointerConversion(x.X)
+	if !ok {
+		return nil, nil, nil, false
+	}
+	array, ok := t.(*ast.ArrayType)
+	if !ok || array.Len == nil {
+		return nil, nil, nil, false
+	}
+	return ptr, array.Elt, x.High, true
+}
+
+// pointerConversion returns p and T if x is a conversion (*T)(p), with
+// any conversion of p to unsafe.Pointer dropped.  Without types, a call
+// (*f)(p) of a function pointer is taken for one.
+func (this *Generator) pointerConversion(x ast.Expr) (ptr, elt ast.Expr, ok bool) {
+	call, ok := x.(*ast.CallExpr)
+	if !ok || len(call.Args) != 1 || this.Info != nil && !this.isConversion(call) {
+		return nil, nil, false
+	}
+	fun := call.Fun
+	for {
+		paren, ok := fun.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		fun = paren.X
+	}
+	star, ok := fun.(*ast.StarExpr)
+	if !ok {
+		return nil, nil, false
+	}
+	return this.stripUnsafePointer(call.Args[0]), star.X, true
+}
+
+// stripUnsafePointer returns x without its conversions to unsafe.Pointer.
+func (this *Generator) stripUnsafePointer(x ast.Expr) ast.Expr {
+	for {
+		call, ok := x.(*ast.CallExpr)
+		if !ok || len(call.Args) != 1 {
+			return x
+		}
+		if !this.isPackageFunc(call.Fun, "unsafe", "Pointer") {
+			return x
+		}
+		x = call.Args[0]
+	}
+}
+
+// stripFirst returns args with the conversions of
+// the first to unsafe.Pointer dropped.
+func (this *Generator) stripFirst(args []ast.Expr) []ast.Expr {
+	if len(args) == 0 {
+		return args
+	}
+	return append([]ast.Expr{this.stripUnsafePointer(args[0])}, args[1:]...)
+}
+
+func isZero(x ast.Expr) bool {
+	lit, ok := x.(*ast.BasicLit)
+	return ok && lit.Kind == token.INT && lit.Value == "0"
+}
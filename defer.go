@@ -0,0 +1,152 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// With Generator.LowerDefer, defer, panic and recover are translated
+// to kernel control flow rather than to Rasta`Defer and plain calls:
+//
+//   - panic(v) is Throw[v, Rasta`Panic];
+//   - defer f(args) evaluates f and args, as Go does, and pushes {f, args}
+//     onto Rasta`$Defers, the deferred calls of the running function;
+//   - a function that defers calls has its body wrapped by lowerDefers,
+//     which runs the deferred calls last in, first out however the body
+//     ends, by returning, by panicking or otherwise;
+//   - while they run, Rasta`$Panic is the value of the panic under way,
+//     or Rasta`Nil, and recover() returns it and sets it to Rasta`Nil,
+//     which stops the panic.
+//
+// A deferred call that panics replaces the panic under way, and the
+// remaining deferred calls still run.
+
+// lowerDefers wraps body, the translation of the body of a function
+// that defers calls, as
+//
+//	Block[{Rasta`$Defers = {}},
+//		CheckAll[body, Function[
+//			Block[{Rasta`$Panic = panic value in #2, or Rasta`Nil},
+//				While[Rasta`$Defers =!= {},
+//					Catch[pop and call First[Rasta`$Defers],
+//						Rasta`Panic, Function[Rasta`$Panic = #1]]];
+//				If[Rasta`$Panic =!= Rasta`Nil, Throw[Rasta`$Panic, Rasta`Panic]];
+//				If[recovered, Null, ReleaseHold[#2]; #1]]]]]
+//
+// CheckAll calls the handler with the result of the body and the
+// control transfer, such as a Throw, that ended it, held.  A function
+// with named results returns them as the deferred calls left them,
+// with ret, the translation of a return of them: the last If is then
+//
+//	If[recovered || returned, ret, ReleaseHold[#2]; #1]
+func lowerDefers(pos token.Pos, body, ret MExpr) MExpr {
+	sym := func(context, name string) MExpr { return newPosSymbol(pos, context, name) }
+	normal := func(context, name string, args ...MExpr) MExpr { return newPosNormal(pos, context, name, args...) }
+	slot := func(n int) MExpr { return normal("System", "Slot", &MExprInteger{Value: n}) }
+	defers := sym("Rasta", "$Defers")
+	panicking := sym("Rasta", "$Panic")
+	nilValue := sym("Rasta", "Nil")
+	tag := sym("Rasta", "Panic")
+
+	// The control transfer #2 is a panic if it is HoldComplete[Throw[v, Rasta`Panic]].
+	isPanic := normal("System", "MatchQ", slot(2),
+		normal("System", "HoldComplete", normal("System", "Throw", normal("System", "Blank"), tag)))
+	value := normal("System", "If", isPanic,
+		normal("System", "Extract", slot(2), normal("System", "List", &MExprInteger{Value: 1}, &MExprInteger{Value: 1})),
+		nilValue)
+	call := normal("System", "Function", normal("System", "CompoundExpression",
+		normal("System", "Set", defers, normal("System", "Rest", defers)),
+		normal("System", "Apply", normal("System", "First", slot(1)), normal("System", "Rest", slot(1))),
+	))
+	run := normal("System", "While", normal("System", "UnsameQ", defers, normal("System", "List")),
+		normal("System", "Catch",
+			&MExprNormal{Hd: call, Arguments: []MExpr{normal("System", "First", defers)}},
+			tag,
+			normal("System", "Function", normal("System", "Set", panicking, slot(1))),
+		))
+	resume := normal("System", "CompoundExpression", normal("System", "ReleaseHold", slot(2)), slot(1))
+	done := normal("System", "If", isPanic, sym("System", "Null"), resume)
+	if ret != nil {
+		// The body returned if it ended normally or by Return.
+		returned := normal("System", "MatchQ", slot(2), normal("System", "Alternatives",
+			normal("System", "HoldComplete"),
+			normal("System", "HoldComplete", normal("System", "Return", normal("System", "BlankNullSequence")))))
+		done = normal("System", "If", normal("System", "Or", isPanic, returned), ret, resume)
+	}
+	handler := normal("System", "Function", normal("System", "Block",
+		normal("System", "List", normal("System", "Set", panicking, value)),
+		normal("System", "CompoundExpression",
+			run,
+			normal("System", "If", normal("System", "UnsameQ", panicking, nilValue),
+				normal("System", "Throw", panicking, tag)),
+			done,
+		),
+	))
+	return normal("System", "Block",
+		normal("System", "List", normal("System", "Set", defers, normal("System", "List"))),
+		normal("System", "CheckAll", body, handler))
+}
+
+// deferCall returns the lowering of defer call:
+// PrependTo[Rasta`$Defers, {f, args...}].
+func (this *Generator) deferCall(pos token.Pos, call *ast.CallExpr) MExpr {
+	var d []MExpr
+	if head, args, owner := this.memoryCall(call); this.CMemory && head != "" {
+		// {Rasta`CFree, p} and the like.
+		d = append([]MExpr{newPosSymbol(pos, "Rasta", head)}, this.memoryExpr(pos, head, args, owner).(*MExprNormal).Arguments...)
+	} else {
+		d = []MExpr{this.translate(call.Fun)}
+		for _, arg := range call.Args {
+			d = append(d, this.translate(arg))
+		}
+	}
+	return newPosNormal(pos, "System", "PrependTo", newPosSymbol(pos, "Rasta", "$Defers"), newPosNormal(pos, "System", "List", d...))
+}
+
+// lowerBuiltin returns the lowering of call if it calls the
+// predeclared panic or recover, and nil otherwise.
+func (this *Generator) lowerBuiltin(call *ast.CallExpr) MExpr {
+	id, ok := call.Fun.(*ast.Ident)
+	if !ok || !this.isPredeclared(id) {
+		return nil
+	}
+	pos := call.Pos()
+	switch {
+	case id.Name == "panic" && len(call.Args) == 1:
+		return newPosNormal(pos, "System", "Throw", this.translate(call.Args[0]), newPosSymbol(pos, "Rasta", "Panic"))
+	case id.Name == "recover" && len(call.Args) == 0:
+		// Function[Rasta`$Panic = Rasta`Nil; #1][Rasta`$Panic]
+		panicking := newPosSymbol(pos, "Rasta", "$Panic")
+		clear := newPosNormal(pos, "System", "Function", newPosNormal(pos, "System", "CompoundExpression",
+			newPosNormal(pos, "System", "Set", panicking, newPosSymbol(pos, "Rasta", "Nil")),
+			newPosNormal(pos, "System", "Slot", &MExprInteger{Value: 1}),
+		))
+		return &MExprNormal{
+			MExprBase: MExprBase{
+				Position: pos,
+			},
+			Hd:        clear,
+			Arguments: []MExpr{panicking},
+		}
+	}
+	return nil
+}
+
+// hasDefer reports whether body defers calls itself,
+// rather than in the function literals in it.
+func hasDefer(body *ast.BlockStmt) bool {
+	found := false
+	if body == nil {
+		return false
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.DeferStmt:
+			found = true
+		case *ast.FuncLit:
+			return false
+		}
+		return !found
+	})
+	return found
+}
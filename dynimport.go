@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/abduld/rasta/cgo"
+)
+
+func dynimportUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprint(os.Stderr, "usage: rasta dynimport [-wl file] binary\n")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+}
+
+// dynimportMain implements "rasta dynimport".  It reports the shared
+// libraries and symbols that a gcc-produced executable or shared object,
+// such as one linked from the translated package, needs at run time.
+func dynimportMain(args []string) {
+	fs := flag.NewFlagSet("dynimport", flag.ExitOnError)
+	wlOut := fs.String("wl", "", "write the Wolfram description to this file instead of standard output")
+	fs.Usage = dynimportUsage(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+	}
+
+	info, err := cgo.DynamicImports(fs.Arg(0))
+	if err != nil {
+		fatalf("%s", err)
+	}
+	wl := dynImportsMExpr(info).String() + "\n"
+	if *wlOut == "" {
+		os.Stdout.WriteString(wl)
+	} else if err := ioutil.WriteFile(*wlOut, []byte(wl), 0666); err != nil {
+		fatalf("%s", err)
+	}
+}
+
+// dynImportsMExpr describes the dynamic imports in info:
+//
+//	Rasta`DynamicImports[<|"Format" -> "elf", "Linker" -> "/lib64/ld-linux-x86-64.so.2",
+//		"Libraries" -> {"libLLVM.so.10", ...},
+//		"Symbols" -> {<|"Name" -> "LLVMContextCreate", "Version" -> "LLVM_10",
+//			"Library" -> "libLLVM.so.10"|>, ...}|>]
+//
+// Linker, Version and Library are Missing[] when not recorded.
+func dynImportsMExpr(info *cgo.DynImportInfo) MExpr {
+	libs := make([]MExpr, len(info.Libraries))
+	for i, l := range info.Libraries {
+		libs[i] = &MExprString{Value: l}
+	}
+	syms := make([]MExpr, len(info.Symbols))
+	for i, s := range info.Symbols {
+		syms[i] = newAssociation(
+			newRule(&MExprString{Value: "Name"}, &MExprString{Value: s.Name}),
+			newRule(&MExprString{Value: "Version"}, stringOrMissing(s.Version)),
+			newRule(&MExprString{Value: "Library"}, stringOrMissing(s.Library)),
+		)
+	}
+	return newNormal(newSymbol("Rasta", "DynamicImports"),
+		newAssociation(
+			newRule(&MExprString{Value: "Format"}, &MExprString{Value: info.Format}),
+			newRule(&MExprString{Value: "Linker"}, stringOrMissing(info.Linker)),
+			newRule(&MExprString{Value: "Libraries"}, newList(libs...)),
+			newRule(&MExprString{Value: "Symbols"}, newList(syms...)),
+		),
+	)
+}
+
+// stringOrMissing returns s as an MExprString, or Missing[] if s is "".
+func stringOrMissing(s string) MExpr {
+	if s == "" {
+		return newNormal(newSymbol("System", "Missing"))
+	}
+	return &MExprString{Value: s}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/abduld/rasta/cgo"
+)
+
+// enumsMExpr returns a Rasta`CEnum description for every C enum seen
+// while translating, with all of its enumerators:
+//
+//	Rasta`CEnum[<|"Name" -> "LLVMOpcode", "Type" -> "UnsignedInteger32",
+//		"Values" -> <|"LLVMRet" -> 1, ...|>|>]
+func enumsMExpr(p *cgo.Package) []MExpr {
+	var defs []MExpr
+	for _, e := range cgo.Enums() {
+		values := make([]MExpr, len(e.Enumerators))
+		for i, ev := range e.Enumerators {
+			values[i] = newRule(&MExprString{Value: ev.Name}, &MExprInteger{Value: int(ev.Value)})
+		}
+		defs = append(defs, newNormal(newSymbol("Rasta", "CEnum"),
+			newAssociation(
+				newRule(&MExprString{Value: "Name"}, &MExprString{Value: e.Name}),
+				newRule(&MExprString{Value: "Type"}, foreignTypeOrOpaque(p, e.Type.Go)),
+				newRule(&MExprString{Value: "Values"}, newAssociation(values...)),
+			),
+		))
+	}
+	return defs
+}
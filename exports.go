@@ -0,0 +1,216 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+	"strings"
+
+	"github.com/abduld/rasta/cgo"
+)
+
+// exportsMExpr describes the Go functions that p exports to C with
+// //export comments, from the shared library lib built from the package:
+//
+//	Rasta`Export["name", {argTypes} -> retType, "doc"];
+//	Rasta`GoFunction["name"] = ForeignFunctionLoad[lib, "name", {argTypes} -> retType];
+//	Rasta`GoCallback["name"] := ForeignPointerLookup[lib, "name"]
+//
+// Rasta`GoFunction calls the Go function from the kernel, and
+// Rasta`GoCallback is a pointer to it for C libraries to call back.
+// The types are those of the C declarations in _cgo_export.h: a method
+// takes its receiver first, and several results come back as a struct.
+func exportsMExpr(p *cgo.Package, lib MExpr) MExpr {
+	var decls []MExpr
+	for _, exp := range p.ExpFunc {
+		fn := exp.Func
+		var args []MExpr
+		if fn.Recv != nil {
+			args = append(args, exportTypes(p, fn.Recv)...)
+		}
+		args = append(args, exportTypes(p, fn.Type.Params)...)
+		var ret MExpr = &MExprString{Value: "Void"}
+		switch results := exportTypes(p, fn.Type.Results); len(results) {
+		case 0:
+		case 1:
+			ret = results[0]
+		default:
+			ret = typeSpecifier("ListTuple", newList(results...))
+		}
+		name := &MExprString{Value: exp.ExpName}
+		sig := newRule(newList(args...), ret)
+		doc := &MExprString{Value: quoteString(docText(exp.Doc))}
+		decls = append(decls,
+			newNormal(newSymbol("Rasta", "Export"), name, sig, doc),
+			newNormal(newSymbol("System", "Set"),
+				newNormal(newSymbol("Rasta", "GoFunction"), name),
+				newNormal(newSymbol("System", "ForeignFunctionLoad"), lib, name, sig),
+			),
+			newNormal(newSymbol("System", "SetDelayed"),
+				newNormal(newSymbol("Rasta", "GoCallback"), name),
+				newNormal(newSymbol("System", "ForeignPointerLookup"), lib, name),
+			),
+		)
+	}
+	return newNormal(newSymbol("System", "CompoundExpression"), decls...)
+}
+
+// docText returns the text of the comments in doc, the doc comment
+// of an exported function as cgo keeps it for _cgo_export.h: one
+// comment after the other, each with its markers and a newline.
+// The text has no final newline.
+func docText(doc string) string {
+	var g ast.CommentGroup
+	for doc != "" {
+		n := strings.IndexByte(doc, '\n')
+		if strings.HasPrefix(doc, "/*") {
+			n = strings.Index(doc, "*/") + len("*/")
+		}
+		if n < 0 {
+			n = len(doc)
+		}
+		g.List = append(g.List, &ast.Comment{Text: doc[:n]})
+		doc = strings.TrimPrefix(doc[n:], "\n")
+	}
+	return strings.TrimSuffix(g.Text(), "\n")
+}
+
+// exportTypes returns the foreign types of the fields in fl,
+// one for each name, or one for an unnamed field.
+func exportTypes(p *cgo.Package, fl *ast.FieldList) []MExpr {
+	if fl == nil {
+		return nil
+	}
+	var types []MExpr
+	for _, f := range fl.List {
+		t := goForeignTypeOrOpaque(p, f.Type)
+		types = append(types, t)
+		for i := 1; i < len(f.Names); i++ {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// goForeignType maps a Go type in the signature of an exported function
+// to the Wolfram foreign type of its C counterpart in _cgo_export.h,
+// where strings, slices and interfaces are the structs GoString,
+// GoSlice and GoInterface.  Go structs defined in the package are
+// passed by value like C structs.  It returns nil if the type has
+// no Wolfram counterpart.
+func goForeignType(p *cgo.Package, x ast.Expr) MExpr {
+	goInt := "Integer64"
+	if p.IntSize == 4 {
+		goInt = "Integer32"
+	}
+	opaque := &MExprString{Value: "OpaqueRawPointer"}
+	switch t := x.(type) {
+	case *ast.Ident:
+		switch t.Name {
+		case "int":
+			return &MExprString{Value: goInt}
+		case "uint":
+			return &MExprString{Value: "Unsigned" + goInt}
+		case "byte":
+			return &MExprString{Value: "UnsignedInteger8"}
+		case "rune":
+			return &MExprString{Value: "Integer32"}
+		case "string":
+			return typeSpecifier("ListTuple", newList(typeSpecifier("RawPointer", &MExprString{Value: "CChar"}), &MExprString{Value: goInt}))
+		case "error":
+			return typeSpecifier("ListTuple", newList(opaque, opaque))
+		}
+		if def := localType(t); def != x {
+			return goForeignType(p, def)
+		}
+	case *ast.StructType:
+		var fields []MExpr
+		for _, f := range t.Fields.List {
+			fields = append(fields, exportTypes(p, &ast.FieldList{List: []*ast.Field{f}})...)
+		}
+		return typeSpecifier("ListTuple", newList(fields...))
+	case *ast.InterfaceType:
+		return typeSpecifier("ListTuple", newList(opaque, opaque))
+	case *ast.ArrayType:
+		if t.Len == nil {
+			elt := goForeignTypeOrOpaque(p, t.Elt)
+			return typeSpecifier("ListTuple", newList(typeSpecifier("RawPointer", elt), &MExprString{Value: goInt}, &MExprString{Value: goInt}))
+		}
+		// An array holds its elements one after the other,
+		// as in a struct with as many fields.
+		n, ok := arrayLen(t.Len)
+		if !ok {
+			return nil
+		}
+		elts := make([]MExpr, n)
+		for i := range elts {
+			elts[i] = goForeignTypeOrOpaque(p, t.Elt)
+		}
+		return typeSpecifier("ListTuple", newList(elts...))
+	case *ast.StarExpr:
+		// As in foreignType, pointers to anything but
+		// numbers are opaque to the kernel.
+		if _, ok := localType(t.X).(*ast.StructType); ok {
+			return opaque
+		}
+		if elt, ok := goForeignType(p, t.X).(*MExprString); ok && elt.Value != "OpaqueRawPointer" && elt.Value != "Void" {
+			return typeSpecifier("RawPointer", elt)
+		}
+		return opaque
+	}
+	return foreignType(p, x)
+}
+
+// localType returns the definition of x if it names
+// a type of the package, and x itself otherwise.
+func localType(x ast.Expr) ast.Expr {
+	for {
+		id, ok := x.(*ast.Ident)
+		if !ok || id.Obj == nil {
+			return x
+		}
+		ts, ok := id.Obj.Decl.(*ast.TypeSpec)
+		if !ok {
+			return x
+		}
+		x = ts.Type
+	}
+}
+
+// arrayLen returns the length of an array type, which is either
+// an integer literal or a constant of the package defined by one.
+func arrayLen(x ast.Expr) (int, bool) {
+	switch x := x.(type) {
+	case *ast.BasicLit:
+		if x.Kind != token.INT {
+			return 0, false
+		}
+		n, err := strconv.ParseInt(x.Value, 0, 0)
+		return int(n), err == nil && n >= 0
+	case *ast.ParenExpr:
+		return arrayLen(x.X)
+	case *ast.Ident:
+		if x.Obj == nil || x.Obj.Kind != ast.Con {
+			return 0, false
+		}
+		vs, ok := x.Obj.Decl.(*ast.ValueSpec)
+		if !ok {
+			return 0, false
+		}
+		for i, name := range vs.Names {
+			if name.Name == x.Name && i < len(vs.Values) {
+				return arrayLen(vs.Values[i])
+			}
+		}
+	}
+	return 0, false
+}
+
+// goForeignTypeOrOpaque is like goForeignType but treats values
+// of unknown type as opaque pointers.
+func goForeignTypeOrOpaque(p *cgo.Package, x ast.Expr) MExpr {
+	if t := goForeignType(p, x); t != nil {
+		return t
+	}
+	return &MExprString{Value: "OpaqueRawPointer"}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"path"
+)
+
+// With Generator.Failures, Go's error convention is translated to the
+// kernel's, in which a function that fails returns a Failure object
+// instead of its results:
+//
+//   - errors.New(msg) is Failure["GoError", <|"Message" -> msg|>], and
+//     fmt.Errorf(format, args...) is the same with the message
+//     Rasta`Sprintf[format, args...];
+//   - a function whose last result is an error returns its other
+//     results, as one value or a list of several, when the error is
+//     nil, and the error otherwise: return x, nil is
+//     Return[x], return x, errors.New(msg) is Return[Failure[...]], and
+//     return x, err is Return[Rasta`Check[err, x]], which is err if it
+//     is a Failure and x if not;
+//   - every other return is a Return too: return is Return[],
+//     return x is Return[x] and return x, y is Return[{x, y}];
+//   - nil is Rasta`Nil, the one value of a nil error: a function
+//     whose only result is an error returns it when there is none;
+//   - x, err = f() is Rasta`Set[{x, err}, Rasta`Results[f[], 2]],
+//     where Rasta`Results[r, n] splits such a result back into the n
+//     results of f, with Rasta`Nil for the error if r is not a Failure;
+//   - err != nil is FailureQ[err] and err == nil is Not[FailureQ[err]],
+//     so that if err != nil { return nil, err } becomes
+//     If[FailureQ[err], Return[err], Null].
+//
+// Without types, an error is the predeclared type error, and a value
+// whose type is error is one named err.
+
+// isError reports whether x is a value of type error.
+func (this *Generator) isError(x ast.Expr) bool {
+	if this.Info != nil {
+		var t types.Type
+		if tv, ok := this.Info.Types[x]; ok {
+			t = tv.Type
+		} else if id, ok := x.(*ast.Ident); ok && this.Info.Defs[id] != nil {
+			t = this.Info.Defs[id].Type()
+		}
+		if t != nil {
+			return types.Identical(t, types.Universe.Lookup("error").Type())
+		}
+	}
+	id, ok := x.(*ast.Ident)
+	return ok && id.Name == "err"
+}
+
+// errorResultCount returns the number of results of ft
+// if the last of them is an error, and 0 otherwise.
+func (this *Generator) errorResultCount(ft *ast.FuncType) int {
+	n := ft.Results.NumFields()
+	if n == 0 {
+		return 0
+	}
+	last := ft.Results.List[len(ft.Results.List)-1].Type
+	if this.Info != nil {
+		if tv, ok := this.Info.Types[last]; ok && tv.IsType() {
+			if types.Identical(tv.Type, types.Universe.Lookup("error").Type()) {
+				return n
+			}
+			return 0
+		}
+	}
+	if id, ok := last.(*ast.Ident); ok && id.Name == "error" && this.isPredeclared(id) {
+		return n
+	}
+	return 0
+}
+
+// isNil reports whether x is the predeclared nil.
+func (this *Generator) isNil(x ast.Expr) bool {
+	id, ok := x.(*ast.Ident)
+	return ok && id.Name == "nil" && this.isPredeclared(id)
+}
+
+// isPackageFunc reports whether fun is the function name of the
+// package with import path pkgPath.  Without types, the package must
+// be referred to by the last element of its path.
+func (this *Generator) isPackageFunc(fun ast.Expr, pkgPath, name string) bool {
+	sel, ok := fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != name {
+		return false
+	}
+	if this.Info != nil {
+		if pkg := this.packageRef(sel); pkg != nil {
+			return pkg.Path() == pkgPath
+		}
+	}
+	id, ok := sel.X.(*ast.Ident)
+	return ok && id.Obj == nil && id.Name == path.Base(pkgPath)
+}
+
+// newError returns the Failure that call makes, if it
+// calls errors.New or fmt.Errorf, and nil otherwise.
+func (this *Generator) newError(call *ast.CallExpr) MExpr {
+	var msg MExpr
+	switch {
+	case this.isPackageFunc(call.Fun, "errors", "New") && len(call.Args) == 1:
+		msg = this.translate(call.Args[0])
+	case this.isPackageFunc(call.Fun, "fmt", "Errorf") && len(call.Args) >= 1:
+		var args []MExpr
+		for _, arg := range call.Args {
+			args = append(args, this.translate(arg))
+		}
+		msg = newPosNormal(call.Pos(), "Rasta", "Sprintf", args...)
+	default:
+		return nil
+	}
+	return newPosNormal(call.Pos(), "System", "Failure",
+		&MExprString{
+			MExprBase: MExprBase{
+				Position: call.Pos(),
+			},
+			Value: "GoError",
+		},
+		newAssociation(newRule(&MExprString{Value: "Message"}, msg)),
+	)
+}
+
+// errorReturn translates the return statement node of a function
+// whose last result is an error, or returns nil if it returns the
+// results of a call, or the named results, as they are.
+func (this *Generator) errorReturn(node *ast.ReturnStmt) MExpr {
+	if len(node.Results) != this.errorResults {
+		return nil
+	}
+	n := len(node.Results) - 1
+	var values MExpr
+	switch n {
+	case 0:
+		values = newPosSymbol(node.Pos(), "Rasta", "Nil")
+	case 1:
+		values = this.translate(node.Results[0])
+	default:
+		var vs []MExpr
+		for _, res := range node.Results[:n] {
+			vs = append(vs, this.translate(res))
+		}
+		values = newPosNormal(node.Pos(), "System", "List", vs...)
+	}
+	err := node.Results[n]
+	var result MExpr
+	if this.isNil(err) {
+		result = values
+	} else if call, ok := err.(*ast.CallExpr); ok {
+		result = this.newError(call)
+	}
+	if result == nil {
+		result = newPosNormal(node.Pos(), "Rasta", "Check", this.translate(err), values)
+	}
+	return newPosNormal(node.Pos(), "System", "Return", result)
+}
+
+// plainReturn translates the return statement node of a function
+// whose last result is not an error, or that returns the results of
+// a call as they are, as Return[] with its results.
+func (this *Generator) plainReturn(node *ast.ReturnStmt) MExpr {
+	switch len(node.Results) {
+	case 0:
+		return newPosNormal(node.Pos(), "System", "Return")
+	case 1:
+		return newPosNormal(node.Pos(), "System", "Return", this.translate(node.Results[0]))
+	}
+	values := make([]MExpr, len(node.Results))
+	for i, res := range node.Results {
+		values[i] = this.translate(res)
+	}
+	return newPosNormal(node.Pos(), "System", "Return", newPosNormal(node.Pos(), "System", "List", values...))
+}
+
+// errorAssign translates x, ..., err = f() and its := form,
+// or returns nil if node is not such an assignment.
+func (this *Generator) errorAssign(node *ast.AssignStmt) MExpr {
+	if len(node.Lhs) < 2 || len(node.Rhs) != 1 || !this.isError(node.Lhs[len(node.Lhs)-1]) {
+		return nil
+	}
+	if _, ok := node.Rhs[0].(*ast.CallExpr); !ok {
+		return nil
+	}
+	return this.resultsAssign(node, this.translate(node.Rhs[0]))
+}
+
+// resultsAssign returns Rasta`Set[{x, ...}, Rasta`Results[rhs, n]] for
+// the assignment node of the n values of one call, translated as rhs.
+func (this *Generator) resultsAssign(node *ast.AssignStmt, rhs MExpr) MExpr {
+	var lhs []MExpr
+	for _, x := range node.Lhs {
+		lhs = append(lhs, this.translate(x))
+	}
+	return newPosNormal(node.Pos(), "Rasta", "Set",
+		newPosNormal(node.Pos(), "System", "List", lhs...),
+		newPosNormal(node.Pos(), "Rasta", "Results", rhs, &MExprInteger{Value: len(lhs)}),
+	)
+}
+
+// errorCompare translates err != nil and err == nil,
+// or returns nil if node is not such a comparison.
+func (this *Generator) errorCompare(node *ast.BinaryExpr) MExpr {
+	if node.Op != token.NEQ && node.Op != token.EQL {
+		return nil
+	}
+	x, y := node.X, node.Y
+	if this.isNil(x) {
+		x, y = y, x
+	}
+	if !this.isNil(y) || !this.isError(x) {
+		return nil
+	}
+	failed := newPosNormal(node.Pos(), "System", "FailureQ", this.translate(x))
+	if node.Op == token.EQL {
+		return newPosNormal(node.Pos(), "System", "Not", failed)
+	}
+	return failed
+}
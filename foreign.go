@@ -0,0 +1,182 @@
+package main
+
+import (
+	"go/ast"
+
+	"github.com/abduld/rasta/cgo"
+)
+
+// Map from cgo names for C base types to Wolfram foreign types.
+var cToForeign = map[string]string{
+	"_Ctype_char":         "CChar",
+	"_Ctype_schar":        "CSignedChar",
+	"_Ctype_uchar":        "CUnsignedChar",
+	"_Ctype_unsignedchar": "CUnsignedChar",
+	"_Ctype_short":        "CShort",
+	"_Ctype_ushort":       "CUnsignedShort",
+	"_Ctype_int":          "CInt",
+	"_Ctype_uint":         "CUnsignedInt",
+	"_Ctype_long":         "CLong",
+	"_Ctype_ulong":        "CUnsignedLong",
+	"_Ctype_longlong":     "CLongLong",
+	"_Ctype_ulonglong":    "CUnsignedLongLong",
+	"_Ctype_float":        "CFloat",
+	"_Ctype_double":       "CDouble",
+	"_Ctype_size_t":       "CSizeT",
+	"_Ctype__Bool":        "CBool",
+	"_Ctype_void":         "Void",
+}
+
+// Map from Go types used by cgo to Wolfram foreign types.
+var goToForeign = map[string]string{
+	"bool":    "CBool",
+	"int8":    "Integer8",
+	"int16":   "Integer16",
+	"int32":   "Integer32",
+	"int64":   "Integer64",
+	"uint8":   "UnsignedInteger8",
+	"uint16":  "UnsignedInteger16",
+	"uint32":  "UnsignedInteger32",
+	"uint64":  "UnsignedInteger64",
+	"float32": "Real32",
+	"float64": "Real64",
+}
+
+// foreignMExpr returns a ForeignFunctionLoad declaration for every
+// C function referenced by the package, of the form
+//
+//	Rasta`C["f"] = ForeignFunctionLoad[lib, "f", {argTypes} -> retType]
+//
+// so that the Rasta`C["f"][...] calls in the translated code reach the
+// C library.  The helpers cgo defines itself, like C.CString, are skipped.
+// Function-like macros cannot be loaded, so they are described with
+// Rasta`CMacro instead.
+func foreignMExpr(p *cgo.Package, lib MExpr) MExpr {
+	var decls []MExpr
+	for _, key := range sortedNames(p.Name) {
+		n := p.Name[key]
+		if n.Kind == "macro" {
+			decls = append(decls, macroMExpr(n))
+			continue
+		}
+		if n.Kind != "func" || n.FuncType == nil || n.AddError || cgo.IsBuiltin(n.Go) {
+			continue
+		}
+		args := make([]MExpr, len(n.FuncType.Params))
+		for i, t := range n.FuncType.Params {
+			args[i] = foreignTypeOrOpaque(p, t.Go)
+		}
+		var ret MExpr = &MExprString{Value: "Void"}
+		if n.FuncType.Result != nil {
+			ret = foreignTypeOrOpaque(p, n.FuncType.Result.Go)
+		}
+		decls = append(decls, newNormal(newSymbol("System", "Set"),
+			newNormal(newSymbol("Rasta", "C"), &MExprString{Value: n.Go}),
+			newNormal(newSymbol("System", "ForeignFunctionLoad"),
+				lib,
+				&MExprString{Value: n.C},
+				newRule(newList(args...), ret),
+			),
+		))
+	}
+	return newNormal(newSymbol("System", "CompoundExpression"), decls...)
+}
+
+// foreignType maps the Go type cgo uses for a C value to the
+// corresponding Wolfram foreign type.  Typedefs are resolved to
+// their underlying type, so enums become integers of the enum's size.
+// It returns nil if the type has no Wolfram counterpart.
+func foreignType(p *cgo.Package, x ast.Expr) MExpr {
+	switch t := x.(type) {
+	case *ast.Ident:
+		if t.Name == "uintptr" {
+			return uintptrType(p)
+		}
+		if s, ok := cToForeign[t.Name]; ok {
+			return &MExprString{Value: s}
+		}
+		if s, ok := goToForeign[t.Name]; ok {
+			return &MExprString{Value: s}
+		}
+		if def := structDef(t); def != nil {
+			// Structs passed by value.  Unions, packed structs and
+			// bit-fields have no ListTuple layout and stay opaque.
+			if def.Union || def.Packed {
+				return nil
+			}
+			for _, f := range def.Fields {
+				if f.BitSize > 0 {
+					return nil
+				}
+			}
+			fields := make([]MExpr, len(def.Fields))
+			for i, f := range def.Fields {
+				fields[i] = foreignTypeOrOpaque(p, f.Type.Go)
+			}
+			return typeSpecifier("ListTuple", newList(fields...))
+		}
+		if def := cgo.LookupTypedef(t.Name); def != nil && def.Go != x {
+			return foreignType(p, def.Go)
+		}
+	case *ast.SelectorExpr:
+		if id, ok := t.X.(*ast.Ident); ok && id.Name == "unsafe" && t.Sel.Name == "Pointer" {
+			return &MExprString{Value: "OpaqueRawPointer"}
+		}
+	case *ast.StarExpr:
+		// Pointers to structs, to void and to incomplete
+		// types are opaque to the kernel.
+		if structDef(t.X) != nil {
+			return &MExprString{Value: "OpaqueRawPointer"}
+		}
+		elt := foreignType(p, t.X)
+		if s, ok := elt.(*MExprString); elt == nil || ok && s.Value == "Void" {
+			return &MExprString{Value: "OpaqueRawPointer"}
+		}
+		return typeSpecifier("RawPointer", elt)
+	case *ast.ArrayType:
+		return typeSpecifier("RawPointer", foreignTypeOrOpaque(p, t.Elt))
+	}
+	return nil
+}
+
+// foreignTypeOrOpaque is like foreignType but treats values
+// of unknown type as opaque pointers.
+func foreignTypeOrOpaque(p *cgo.Package, x ast.Expr) MExpr {
+	if t := foreignType(p, x); t != nil {
+		return t
+	}
+	return &MExprString{Value: "OpaqueRawPointer"}
+}
+
+// uintptrType returns the foreign type of a uintptr, an unsigned
+// integer of the size of a pointer on p's target.
+func uintptrType(p *cgo.Package) MExpr {
+	if p.PtrSize == 4 {
+		return &MExprString{Value: "UnsignedInteger32"}
+	}
+	return &MExprString{Value: "UnsignedInteger64"}
+}
+
+// structDef returns the definition of the C struct type x,
+// looking through typedefs, or nil if x is not a struct.
+func structDef(x ast.Expr) *cgo.Type {
+	for {
+		id, ok := x.(*ast.Ident)
+		if !ok {
+			return nil
+		}
+		def := cgo.LookupTypedef(id.Name)
+		if def == nil || def.Go == x {
+			return nil
+		}
+		if def.Fields != nil {
+			return def
+		}
+		x = def.Go
+	}
+}
+
+// typeSpecifier returns the FullForm of the type "name"::[arg].
+func typeSpecifier(name string, arg MExpr) MExpr {
+	return newNormal(newNormal(newSymbol("System", "TypeSpecifier"), &MExprString{Value: name}), arg)
+}
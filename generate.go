@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/abduld/rasta/cgo"
+)
+
+func generateUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprint(os.Stderr, "usage: rasta generate [-context ctx] [-operators] [-lowerdefer] [-failures] [-cmemory] [-stdlib [-stdlibmap file]] [-types [-typed] [-parseheaders]] -- [compiler options] file.go ...\n")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+}
+
+// generateMain implements "rasta generate".  It writes the Wolfram
+// translation of the Go code in the input files, which make up one
+// package, to standard output.  With -types the files are type-checked
+// first, with the names in C resolved by cgo, so that the translation
+// can tell conversions, method calls, field accesses and references
+// to other packages apart.  With -operators, operators are translated
+// to heads with Go's semantics, given the operand types with -types.
+// With -lowerdefer, defer, panic and recover become kernel control flow
+// that runs deferred calls on return and on panic.  With -failures,
+// errors become Failure objects, returned in place of a function's
+// results.  With -cmemory, C.CString, C.GoString, C.free and views of C
+// arrays become heads that say who owns the memory.  With -stdlib,
+// calls of the standard library functions in the table of StdMapping,
+// and of those in the file given by -stdlibmap, become the Wolfram
+// functions that do the same, and the imports and names that have no
+// mapping are reported on standard error.
+//
+// Identifiers become symbols in the package's context, pkg`Private`
+// unless -context says otherwise, mangled as SymbolTable describes.
+// The translation ends with the table of symbols, Rasta`Symbols.
+func generateMain(args []string) {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	context := fs.String("context", "", "put the symbols for identifiers in this context (default pkg`Private`)")
+	operators := fs.Bool("operators", false, "translate operators to heads with Go's semantics, such as Rasta`Quo")
+	lowerDefer := fs.Bool("lowerdefer", false, "translate defer, panic and recover to kernel control flow")
+	failures := fs.Bool("failures", false, "translate errors to Failure objects returned instead of results")
+	cMemory := fs.Bool("cmemory", false, "translate cgo's memory idioms to heads that say who owns the memory")
+	stdlib := fs.Bool("stdlib", false, "translate calls of standard library functions to Wolfram ones")
+	stdlibMap := fs.String("stdlibmap", "", "with -stdlib, add the mappings in this file to the standard ones")
+	checkTypes := fs.Bool("types", false, "type-check the files and translate according to the types")
+	typed := fs.Bool("typed", false, "with -types, translate each expression as Rasta`Typed[expr, type]")
+	parse := fs.Bool("parseheaders", false, "with -types, classify C names by parsing the headers instead of compiling probe programs")
+	fs.Usage = generateUsage(fs)
+	fs.Parse(args)
+
+	// As for godefs, everything before the first Go file
+	// is an option for the C compiler.
+	args = fs.Args()
+	var i int
+	for i = len(args); i > 0; i-- {
+		if !strings.HasSuffix(args[i-1], ".go") {
+			break
+		}
+	}
+	if i == len(args) {
+		fs.Usage()
+	}
+	goFiles := args[i:]
+
+	fset := token.NewFileSet()
+	files := make([]*ast.File, len(goFiles))
+	usesC := false
+	for i, input := range goFiles {
+		f, err := parser.ParseFile(fset, input, nil, parser.ParseComments)
+		if err != nil {
+			fatalf("%s", err)
+		}
+		files[i] = f
+		usesC = usesC || importsC(f)
+	}
+
+	gen := &Generator{Typed: *typed, Operators: *operators, LowerDefer: *lowerDefer, Failures: *failures, CMemory: *cMemory}
+	if *stdlib {
+		gen.Stdlib = NewStdMapping()
+		if *stdlibMap != "" {
+			f, err := os.Open(*stdlibMap)
+			if err != nil {
+				fatalf("%s", err)
+			}
+			err = gen.Stdlib.Load(f)
+			f.Close()
+			if err != nil {
+				fatalf("%s: %s", *stdlibMap, err)
+			}
+		}
+		for _, f := range files {
+			gen.Stdlib.AddImports(f)
+		}
+	}
+	if *checkTypes {
+		var p *cgo.Package
+		if usesC {
+			// Only the names matter here: keep cgo's output
+			// files out of the way.
+			p = cgo.NewPackage(args[:i], cgo.Options{Sink: new(cgo.MemSink)})
+			p.ParseHeaders = *parse
+			for _, f := range readFiles(p, goFiles) {
+				p.Translate(f)
+				p.Record(f)
+			}
+		}
+		var errs []error
+		gen.Info, errs = typeCheck(fset, files, p)
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+	}
+
+	if *context == "" {
+		*context = files[0].Name.Name + "`Private`"
+	}
+	gen.Symbols = NewSymbolTable(*context)
+	gen.Declare(files...)
+
+	for _, f := range files {
+		gen.Program = make(chan MExpr)
+		done := make(chan bool, 1)
+		go func() {
+			gen.Visit(f)
+			done <- true
+		}()
+	loop:
+		for {
+			select {
+			case mexpr := <-gen.Program:
+				fmt.Println(mexpr)
+			case <-done:
+				break loop
+			}
+		}
+	}
+	fmt.Println(gen.Symbols.MExpr())
+	if gen.Stdlib != nil {
+		for _, name := range gen.Stdlib.Unmapped() {
+			fmt.Fprintf(os.Stderr, "rasta: no Wolfram mapping for %s\n", name)
+		}
+	}
+}
+
+// importsC reports whether f imports the pseudo-package C.
+func importsC(f *ast.File) bool {
+	for _, imp := range f.Imports {
+		if path, err := strconv.Unquote(imp.Path.Value); err == nil && path == "C" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/abduld/rasta/cgo"
+)
+
+func godefsUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprint(os.Stderr, "usage: rasta godefs [-wl file] [-allenums] [-parseheaders] [-reproducible [-trimpath prefixes]] -- [compiler options] file.go ...\n")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+}
+
+// godefsMain implements "rasta godefs".  Like cgo -godefs, it writes Go
+// definitions for the C types and constants referred to by the input files
+// to standard output.  It also writes the same definitions as Wolfram code,
+// to the file given by -wl, so that the two do not end up in one stream.
+func godefsMain(args []string) {
+	fs := flag.NewFlagSet("godefs", flag.ExitOnError)
+	wlOut := fs.String("wl", "_rasta_godefs.wl", "write Wolfram definitions to this file")
+	all := fs.Bool("allenums", false, "describe every C enum in the headers, not just the referenced ones")
+	parse := fs.Bool("parseheaders", false, "classify C names by parsing the headers instead of compiling probe programs")
+	reproducible := fs.Bool("reproducible", false, "make the output independent of where and how often it is generated")
+	trimPath := fs.String("trimpath", "", "with -reproducible, remove these ;-separated prefixes from recorded file names")
+	fs.Usage = godefsUsage(fs)
+	fs.Parse(args)
+
+	// Find first arg that looks like a go file and assume everything before
+	// that are options to pass to gcc.
+	args = fs.Args()
+	var i int
+	for i = len(args); i > 0; i-- {
+		if !strings.HasSuffix(args[i-1], ".go") {
+			break
+		}
+	}
+	if i == len(args) {
+		fs.Usage()
+	}
+	goFiles := args[i:]
+
+	p := cgo.NewPackage(args[:i], cgo.Options{Godefs: true, Reproducible: *reproducible, TrimPath: *trimPath})
+	p.AllEnums = *all
+	p.ParseHeaders = *parse
+	for i, f := range readFiles(p, goFiles) {
+		input := goFiles[i]
+		p.Translate(f)
+		p.PackagePath = f.Package
+		p.Record(f)
+		os.Stdout.WriteString(p.GodefsOutput(f, input))
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "(* Created by rasta godefs - DO NOT EDIT *)\n")
+	fmt.Fprintf(&buf, "(* %s *)\n\n", p.CommandLine())
+	fmt.Fprintf(&buf, "%s\n", godefsMExpr(p))
+	if err := ioutil.WriteFile(*wlOut, buf.Bytes(), 0666); err != nil {
+		fatalf("%s", err)
+	}
+}
+
+// godefsMExpr returns the Wolfram form of the C definitions collected in p:
+// one Rasta`CStruct or Rasta`CUnion for every C struct or union type that was laid out,
+// one Rasta`CEnum for every C enum, and the constants as a list of rules.
+func godefsMExpr(p *cgo.Package) MExpr {
+	var defs []MExpr
+	for _, name := range cgo.TypedefNames() {
+		t := cgo.LookupTypedef(name)
+		if t.Fields == nil {
+			continue
+		}
+		defs = append(defs, cStructMExpr(t))
+	}
+	defs = append(defs, enumsMExpr(p)...)
+
+	var consts []MExpr
+	for _, key := range sortedNames(p.Name) {
+		n := p.Name[key]
+		if n.Kind != "const" || n.Const == "" {
+			continue
+		}
+		consts = append(consts, newRule(&MExprString{Value: n.Go}, constMExpr(n.Const)))
+	}
+	defs = append(defs, newNormal(newSymbol("Rasta", "CConstants"), newList(consts...)))
+
+	for _, key := range sortedNames(p.Name) {
+		if n := p.Name[key]; n.Kind == "macro" {
+			defs = append(defs, macroMExpr(n))
+		}
+	}
+	return newNormal(newSymbol("System", "CompoundExpression"), defs...)
+}
+
+// cStructMExpr returns Rasta`CStruct[name, {field -> {offset, type}, ...}],
+// or Rasta`CUnion for a union.  A bit-field is described by
+// {offset, type, bitOffset, bitWidth}, where offset is that of its
+// storage unit.  The type of an anonymous struct or union member is
+// its own description.  A packed struct ends with "Packed" -> True.
+func cStructMExpr(t *cgo.Type) MExpr {
+	var fields []MExpr
+	for _, f := range t.Fields {
+		var ctype MExpr = &MExprString{Value: f.Type.C.String()}
+		if f.Name == "" && f.Type.Fields != nil {
+			ctype = cStructMExpr(f.Type)
+		}
+		layout := []MExpr{&MExprInteger{Value: int(f.Offset)}, ctype}
+		if f.BitSize > 0 {
+			layout = append(layout, &MExprInteger{Value: int(f.BitOffset)}, &MExprInteger{Value: int(f.BitSize)})
+		}
+		fields = append(fields, newRule(&MExprString{Value: f.Name}, newList(layout...)))
+	}
+	head := "CStruct"
+	if t.Union {
+		head = "CUnion"
+	}
+	args := []MExpr{&MExprString{Value: t.C.String()}, newList(fields...)}
+	if t.Packed {
+		args = append(args, newRule(&MExprString{Value: "Packed"}, newSymbol("System", "True")))
+	}
+	return newNormal(newSymbol("Rasta", head), args...)
+}
+
+// constMExpr converts the Go spelling of a cgo constant into an MExpr.
+func constMExpr(s string) MExpr {
+	if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return &MExprInteger{Value: int(i)}
+	}
+	if u, err := strconv.ParseUint(s, 0, 64); err == nil {
+		return &MExprUnsignedInteger{Value: u}
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return &MExprReal{Value: f}
+	}
+	if u, err := strconv.Unquote(s); err == nil {
+		return &MExprString{Value: quoteString(u)}
+	}
+	return &MExprString{Value: quoteString(s)}
+}
+
+// macroMExpr returns Rasta`CMacro[name, {params}, body] for a function-like macro.
+func macroMExpr(n *cgo.Name) MExpr {
+	params := make([]MExpr, len(n.Params))
+	for i, param := range n.Params {
+		params[i] = &MExprString{Value: param}
+	}
+	return newNormal(newSymbol("Rasta", "CMacro"),
+		&MExprString{Value: n.C},
+		newList(params...),
+		&MExprString{Value: quoteString(n.Define)},
+	)
+}
+
+func sortedNames(m map[string]*cgo.Name) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
@@ -4,10 +4,12 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"go/types"
 	"io/ioutil"
 
 	_ "github.com/k0kubun/pp"
 	//_ "llvm.org/llvm/bindings/go/llvm"
+	"flag"
 	"fmt"
 	"strconv"
 	"strings"
@@ -18,37 +20,17 @@ import (
 	"github.com/k0kubun/pp"
 	"os"
 	"path/filepath"
-	"runtime"
 )
 
-var ptrSizeMap = map[string]int64{
-	"386":      4,
-	"amd64":    8,
-	"arm":      4,
-	"arm64":    8,
-	"mips64":   8,
-	"mips64le": 8,
-	"ppc64":    8,
-	"ppc64le":  8,
-	"s390":     4,
-	"s390x":    8,
-}
-
-var intSizeMap = map[string]int64{
-	"386":      4,
-	"amd64":    8,
-	"arm":      4,
-	"arm64":    8,
-	"mips64":   8,
-	"mips64le": 8,
-	"ppc64":    8,
-	"ppc64le":  8,
-	"s390":     4,
-	"s390x":    4,
-}
-
 var nerrors int
 
+var allEnums = flag.Bool("allenums", false, "describe every C enum in the headers, not just the referenced ones")
+var enumConsts = flag.Bool("enumconsts", false, "write a Go constant block for every C enum")
+var parseHeaders = flag.Bool("parseheaders", false, "classify C names by parsing the headers instead of compiling probe programs")
+var reproducible = flag.Bool("reproducible", false, "make the output independent of where and how often it is generated")
+var trimPath = flag.String("trimpath", "", "with -reproducible, remove these ;-separated prefixes from recorded file names")
+var foreignLib = flag.String("lib", "", "library to load C functions from with ForeignFunctionLoad (default Rasta`$CLibrary)")
+
 // Die with an error message.
 func fatalf(msg string, args ...interface{}) {
 	// If we've already printed other errors, they might have
@@ -60,38 +42,23 @@ func fatalf(msg string, args ...interface{}) {
 }
 
 func main() {
-	goarch := runtime.GOARCH
-	if s := os.Getenv("GOARCH"); s != "" {
-		goarch = s
-	}
-	ptrSize := ptrSizeMap[goarch]
-	if ptrSize == 0 {
-		fatalf("unknown ptrSize for $GOARCH %q", goarch)
-	}
-	intSize := intSizeMap[goarch]
-	if intSize == 0 {
-		fatalf("unknown intSize for $GOARCH %q", goarch)
-	}
-	p := &cgo.Package{
-		PtrSize: ptrSize,
-		IntSize: intSize,
-		CgoFlags: map[string][]string{
-			"CFLAGS": []string{
-				"-D__STDC_LIMIT_MACROS=1",
-			},
-			"LDFLAGS": []string{
-				"llvm-config --libdir",
-			},
-		},
-		GccOptions: []string{
-			"-D__STDC_LIMIT_MACROS=1",
-			"-D__STDC_CONSTANT_MACROS=1",
-			"-I/usr/local/include",
-			"-I/Users/abduld/Code/go/src/llvm.org/llvm/bindings/go/llvm",
-		},
-		Written: make(map[string]bool),
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "godefs":
+			godefsMain(os.Args[2:])
+			return
+		case "dynimport":
+			dynimportMain(os.Args[2:])
+			return
+		case "translate":
+			translateMain(os.Args[2:])
+			return
+		case "generate":
+			generateMain(os.Args[2:])
+			return
+		}
 	}
-
+	flag.Parse()
 	goFiles := []string{
 		"/Users/abduld/Code/go/src/llvm.org/llvm/bindings/go/llvm/target.go",
 		"/Users/abduld/Code/go/src/llvm.org/llvm/bindings/go/llvm/llvm_config.go",
@@ -100,18 +67,19 @@ func main() {
 	//goFiles := []string{
 	//	"test_cgo.txt",
 	//}
-	fs := make([]*cgo.File, len(goFiles))
-	for i, input := range goFiles {
-		f := new(cgo.File)
-		f.ReadGo(input)
-		f.DiscardCgoDirectives()
-		fs[i] = f
-	}
+
+	// The flags come from the files' #cgo directives.  As with
+	// go build, headers are also looked for in the package's directory.
+	p := cgo.NewPackage(nil, cgo.Options{ObjDir: "_obj", Reproducible: *reproducible, TrimPath: *trimPath})
+	p.GccOptions = []string{"-I", filepath.Dir(goFiles[0])}
+	p.AllEnums = *allEnums
+	p.EnumConsts = *enumConsts
+	p.ParseHeaders = *parseHeaders
+
+	fs := readFiles(p, goFiles)
 	// make sure that _obj directory exists, so that we can write
 	// all the output files there.
-	os.Mkdir("_obj", 0777)
-	objDir := "_obj"
-	objDir += string(filepath.Separator)
+	os.Mkdir(p.ObjDir, 0777)
 	for i, input := range goFiles {
 		f := fs[i]
 		p.Translate(f)
@@ -119,6 +87,44 @@ func main() {
 		p.Record(f)
 		p.WriteOutput(f, input)
 	}
+	p.WriteDefs()
+
+	writeWolfram(p, *foreignLib)
+}
+
+// readFiles reads goFiles into p and applies their #cgo directives.
+// All the directives are seen before any of the files is translated,
+// so that every file is translated with the package's flags.
+func readFiles(p *cgo.Package, goFiles []string) []*cgo.File {
+	fs := make([]*cgo.File, len(goFiles))
+	for i, input := range goFiles {
+		fs[i] = p.ReadFile(input)
+		p.ParseFlags(fs[i], input)
+	}
+	return fs
+}
+
+// writeWolfram writes the Wolfram Language descriptions of p's
+// C functions, of the Go functions it exports, and of its enums
+// to its ObjDir.  The functions are loaded
+// from libName, or from Rasta`$CLibrary if libName is "".
+func writeWolfram(p *cgo.Package, libName string) {
+	var lib MExpr = &MExprSymbol{Context: "Rasta", Name: "$CLibrary"}
+	if libName != "" {
+		lib = &MExprString{Value: libName}
+	}
+	wl := foreignMExpr(p, lib).String() + "\n"
+	if err := ioutil.WriteFile(filepath.Join(p.ObjDir, "_rasta_foreign.wl"), []byte(wl), 0666); err != nil {
+		fatalf("%s", err)
+	}
+	exports := exportsMExpr(p, newSymbol("Rasta", "$GoLibrary")).String() + "\n"
+	if err := ioutil.WriteFile(filepath.Join(p.ObjDir, "_rasta_exports.wl"), []byte(exports), 0666); err != nil {
+		fatalf("%s", err)
+	}
+	enums := newNormal(newSymbol("System", "CompoundExpression"), enumsMExpr(p)...)
+	if err := ioutil.WriteFile(filepath.Join(p.ObjDir, "_rasta_enums.wl"), []byte(enums.String()+"\n"), 0666); err != nil {
+		fatalf("%s", err)
+	}
 }
 
 type MExpr interface {
@@ -146,6 +152,12 @@ type MExprInteger struct {
 	Value int
 }
 
+// MExprUnsignedInteger is an integer too large for an MExprInteger.
+type MExprUnsignedInteger struct {
+	MExprBase
+	Value uint64
+}
+
 type MExprReal struct {
 	MExprBase
 	Value float64
@@ -164,6 +176,56 @@ type MExprNormal struct {
 }
 type Generator struct {
 	Program chan MExpr
+
+	// Info holds the types of the package being translated, as
+	// found by typeCheck, or is nil to translate from syntax alone.
+	// With it, selectors and calls are translated according to what
+	// they denote.
+	Info *types.Info
+	// Typed, with Info, translates each expression whose type is
+	// known as Rasta`Typed[expr, "type"].
+	Typed bool
+	// Operators translates operators to heads with Go's semantics,
+	// such as Rasta`Quo for /, instead of Rasta`BinaryExpr["/", x, y].
+	Operators bool
+	// Symbols, if not nil, names the symbols for identifiers, which
+	// are otherwise the System symbols of the same name.
+	Symbols *SymbolTable
+	// LowerDefer translates defer, panic and recover to kernel
+	// control flow that runs deferred calls as Go does.
+	LowerDefer bool
+	// Failures translates Go's errors to Failure objects, and
+	// the (T, error) convention to returning one or the other.
+	Failures bool
+	// CMemory translates the ways memory is passed through cgo to
+	// heads that say who owns it, such as Rasta`CString.
+	CMemory bool
+	// Stdlib, if not nil, translates the calls of the standard
+	// library functions that it knows to Wolfram ones.
+	Stdlib *StdMapping
+
+	errorResults int          // the number of results of the function being translated, if the last is an error
+	results      []*ast.Ident // the named results of the function being translated
+	deferring    bool         // whether the function being translated has its deferred calls lowered
+}
+
+// child returns a Generator for translating the parts of a node.
+func (this *Generator) child() *Generator {
+	return &Generator{
+		Program:    make(chan MExpr),
+		Info:       this.Info,
+		Typed:      this.Typed,
+		Operators:  this.Operators,
+		Symbols:    this.Symbols,
+		LowerDefer: this.LowerDefer,
+		Failures:   this.Failures,
+		CMemory:    this.CMemory,
+		Stdlib:     this.Stdlib,
+
+		errorResults: this.errorResults,
+		results:      this.results,
+		deferring:    this.deferring,
+	}
 }
 
 func (this *MExprNormal) Head() MExpr {
@@ -213,6 +275,12 @@ func (this *MExprString) String() string {
 	return "\"" + this.Value + "\""
 }
 
+// quoteString escapes s for the Value of an MExprString,
+// which is printed between quotes as it is.
+func quoteString(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`).Replace(s)
+}
+
 func (*MExprInteger) Head() MExpr {
 	return &MExprSymbol{
 		Context: "System",
@@ -226,6 +294,19 @@ func (this *MExprInteger) String() string {
 	return strconv.Itoa(this.Value)
 }
 
+func (*MExprUnsignedInteger) Head() MExpr {
+	return &MExprSymbol{
+		Context: "System",
+		Name:    "Integer",
+	}
+}
+func (*MExprUnsignedInteger) Length() int {
+	return 0
+}
+func (this *MExprUnsignedInteger) String() string {
+	return strconv.FormatUint(this.Value, 10)
+}
+
 func (*MExprReal) Head() MExpr {
 	return &MExprSymbol{
 		Context: "System",
@@ -239,6 +320,32 @@ func (this *MExprReal) String() string {
 	return fmt.Sprint(this.Value)
 }
 
+func newSymbol(context, name string) *MExprSymbol {
+	return &MExprSymbol{
+		Context: context,
+		Name:    name,
+	}
+}
+
+func newNormal(hd MExpr, args ...MExpr) *MExprNormal {
+	return &MExprNormal{
+		Hd:        hd,
+		Arguments: args,
+	}
+}
+
+func newRule(lhs, rhs MExpr) *MExprNormal {
+	return newNormal(newSymbol("System", "Rule"), lhs, rhs)
+}
+
+func newList(args ...MExpr) *MExprNormal {
+	return newNormal(newSymbol("System", "List"), args...)
+}
+
+func newAssociation(rules ...MExpr) *MExprNormal {
+	return newNormal(newSymbol("System", "Association"), rules...)
+}
+
 func walkIdentList(v ast.Visitor, list []*ast.Ident) {
 	for _, x := range list {
 		ast.Walk(v, x)
@@ -264,6 +371,25 @@ func walkDeclList(v ast.Visitor, list []ast.Decl) {
 }
 
 func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
+	if x, ok := anode.(ast.Expr); ok && this.Typed && this.Info != nil {
+		if tv, ok := this.Info.Types[x]; ok && tv.IsValue() {
+			gen := this.child()
+			defer close(gen.Program)
+			go gen.visit(x)
+			this.Program <- newPosNormal(x.Pos(), "Rasta", "Typed", <-gen.Program, &MExprString{
+				MExprBase: MExprBase{
+					Position: x.Pos(),
+				},
+				Value: types.TypeString(tv.Type, packageName),
+			})
+			return this
+		}
+	}
+	return this.visit(anode)
+}
+
+// visit translates anode, without the type that Visit may give it.
+func (this *Generator) visit(anode ast.Node) (w ast.Visitor) {
 	switch node := anode.(type) {
 	//case *ast.Comment:
 	//	pp.Println(node.Text)
@@ -275,31 +401,45 @@ func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
 	//	pp.Println(node)
 	case *ast.DeclStmt:
 		this.Visit(node.Decl)
+	case *ast.ParenExpr:
+		// The nesting of the translation keeps the grouping.
+		this.visit(node.X)
 	case *ast.SelectorExpr:
-		gen := &Generator{
-			Program: make(chan MExpr),
+		if this.isC(node.X) {
+			// Rasta`C is keyed by the names in C as they are,
+			// as strings, since they need not be Wolfram symbols.
+			this.Program <- newPosNormal(node.Pos(), "Rasta", "C", &MExprString{
+				MExprBase: MExprBase{
+					Position: node.Sel.Pos(),
+				},
+				Value: node.Sel.Name,
+			})
+			break
+		}
+		if this.Stdlib != nil {
+			if x := this.stdValue(node); x != nil {
+				this.Program <- x
+				break
+			}
+		}
+		sel := this.name(node.Sel)
+		if pkg := this.packageRef(node); pkg != nil {
+			this.Program <- newPosNormal(node.Pos(), "Rasta", "PackageRef", &MExprString{
+				MExprBase: MExprBase{
+					Position: node.X.Pos(),
+				},
+				Value: pkg.Path(),
+			}, sel)
+			break
 		}
+		gen := this.child()
 		defer close(gen.Program)
 		go func() {
 			gen.Visit(node.X)
-			gen.Visit(node.Sel)
 		}()
 		x := <-gen.Program
-		sel := <-gen.Program
-		if x.String() == "C" {
-			this.Program <- &MExprNormal{
-				MExprBase: MExprBase{
-					Position: node.Pos(),
-				},
-				Hd: &MExprSymbol{
-					MExprBase: MExprBase{
-						Position: node.Pos(),
-					},
-					Context: "Rasta",
-					Name:    "C",
-				},
-				Arguments: []MExpr{sel},
-			}
+		if s := this.selection(node); s != nil && s.Kind() == types.FieldVal {
+			this.Program <- newPosNormal(node.Pos(), "Rasta", "FieldAccess", x, sel)
 		} else {
 			this.Program <- &MExprNormal{
 				MExprBase: MExprBase{
@@ -316,17 +456,14 @@ func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
 			}
 		}
 	case *ast.Ident:
-		this.Program <- &MExprSymbol{
-			MExprBase: MExprBase{
-				Position: node.Pos(),
-			},
-			Context: "System",
-			Name:    node.Name,
+		if this.Failures && this.isNil(node) {
+			// A nil error is Rasta`Nil, as in Rasta`Results.
+			this.Program <- newPosSymbol(node.Pos(), "Rasta", "Nil")
+			break
 		}
+		this.Program <- this.symbol(node)
 	case *ast.StarExpr:
-		gen := &Generator{
-			Program: make(chan MExpr),
-		}
+		gen := this.child()
 		defer close(gen.Program)
 		go func() {
 			gen.Visit(node.X)
@@ -347,16 +484,14 @@ func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
 			},
 		}
 	case *ast.TypeSpec:
-		gen := &Generator{
-			Program: make(chan MExpr),
-		}
+		gen := this.child()
 		defer close(gen.Program)
 		go func() {
 			gen.Visit(node.Name)
 			gen.Visit(node.Type)
 		}()
 
-		this.Program <- &MExprNormal{
+		this.Program <- this.generic(node.TypeParams, &MExprNormal{
 			MExprBase: MExprBase{
 				Position: node.Pos(),
 			},
@@ -371,12 +506,10 @@ func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
 				<-gen.Program,
 				<-gen.Program,
 			},
-		}
+		})
 	case *ast.BlockStmt:
 		stmts := []MExpr{}
-		gen := &Generator{
-			Program: make(chan MExpr),
-		}
+		gen := this.child()
 		defer close(gen.Program)
 		go func() {
 			for _, stmt := range node.List {
@@ -386,7 +519,7 @@ func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
 		for range node.List {
 			stmts = append(stmts, <-gen.Program)
 		}
-		this.Program <- &MExprNormal{
+		this.Program <- this.scope(node.Pos(), blockLocals(node.List), &MExprNormal{
 			MExprBase: MExprBase{
 				Position: node.Pos(),
 			},
@@ -398,7 +531,7 @@ func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
 				Name:    "CompoundExpression",
 			},
 			Arguments: stmts,
-		}
+		})
 	case *ast.FuncType:
 
 		this.Program <- &MExprNormal{
@@ -416,16 +549,17 @@ func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
 		}
 	case *ast.FuncDecl:
 
-		gen := &Generator{
-			Program: make(chan MExpr),
-		}
+		gen := this.function(node.Type, node.Body)
 		defer close(gen.Program)
 		go func() {
 			gen.Visit(node.Name)
 			gen.Visit(node.Type)
 			gen.Visit(node.Body)
 		}()
-		this.Program <- &MExprNormal{
+		name := <-gen.Program
+		typ := <-gen.Program
+		body := gen.functionBody(node.Type, node.Body, <-gen.Program)
+		this.Program <- this.generic(node.Type.TypeParams, &MExprNormal{
 			MExprBase: MExprBase{
 				Position: node.Pos(),
 			},
@@ -437,16 +571,26 @@ func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
 				Name:    "Function",
 			},
 			Arguments: []MExpr{
-				<-gen.Program,
-				<-gen.Program,
-				<-gen.Program,
+				name,
+				typ,
+				body,
 			},
+		})
+	case *ast.FuncLit:
+		// Function[{params}, body], callable in the kernel.
+		var params []MExpr
+		for _, field := range node.Type.Params.List {
+			for _, name := range field.Names {
+				params = append(params, this.name(name))
+			}
 		}
+		gen := this.function(node.Type, node.Body)
+		defer close(gen.Program)
+		body := gen.functionBody(node.Type, node.Body, gen.translate(node.Body))
+		this.Program <- newPosNormal(node.Pos(), "System", "Function", newPosNormal(node.Pos(), "System", "List", params...), body)
 	case *ast.ValueSpec:
 
-		gen := &Generator{
-			Program: make(chan MExpr),
-		}
+		gen := this.child()
 		defer close(gen.Program)
 		go func() {
 			if len(node.Names) > 1 {
@@ -515,9 +659,7 @@ func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
 		}
 	case *ast.GenDecl:
 
-		gen := &Generator{
-			Program: make(chan MExpr),
-		}
+		gen := this.child()
 		defer close(gen.Program)
 		go func() {
 			for _, spec := range node.Specs {
@@ -592,9 +734,11 @@ func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
 		}
 		return nil
 	case *ast.DeferStmt:
-		gen := &Generator{
-			Program: make(chan MExpr),
+		if this.LowerDefer {
+			this.Program <- this.deferCall(node.Pos(), node.Call)
+			break
 		}
+		gen := this.child()
 		defer close(gen.Program)
 		go func() {
 			gen.Visit(node.Call)
@@ -616,9 +760,45 @@ func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
 			Arguments: args,
 		}
 	case *ast.CallExpr:
-		gen := &Generator{
-			Program: make(chan MExpr),
+		if this.LowerDefer {
+			if call := this.lowerBuiltin(node); call != nil {
+				this.Program <- call
+				break
+			}
+		}
+		if this.Failures {
+			if failure := this.newError(node); failure != nil {
+				this.Program <- failure
+				break
+			}
+		}
+		if this.CMemory {
+			if head, args, owner := this.memoryCall(node); head != "" {
+				this.Program <- this.memoryExpr(node.Pos(), head, args, owner)
+				break
+			}
+		}
+		if this.Stdlib != nil {
+			if call := this.stdCall(node); call != nil {
+				this.Program <- call
+				break
+			}
+		}
+		if this.isConversion(node) {
+			this.Program <- newPosNormal(node.Pos(), "Rasta", "Convert", this.translate(node.Fun), this.translate(node.Args[0]))
+			break
 		}
+		if sel, ok := node.Fun.(*ast.SelectorExpr); ok {
+			if s := this.selection(sel); s != nil && s.Kind() == types.MethodVal {
+				args := []MExpr{this.translate(sel.X), this.name(sel.Sel)}
+				for _, arg := range node.Args {
+					args = append(args, this.translate(arg))
+				}
+				this.Program <- newPosNormal(node.Pos(), "Rasta", "MethodCall", args...)
+				break
+			}
+		}
+		gen := this.child()
 		defer close(gen.Program)
 		go func() {
 			gen.Visit(node.Fun)
@@ -639,12 +819,26 @@ func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
 			Arguments: args,
 		}
 	case *ast.AssignStmt:
-		genLhs := &Generator{
-			Program: make(chan MExpr),
+		if op := assignOp(node.Tok); op != token.ILLEGAL {
+			// x op= y is translated as x = x op y.
+			bin := &ast.BinaryExpr{X: node.Lhs[0], OpPos: node.TokPos, Op: op, Y: node.Rhs[0]}
+			this.Program <- newPosNormal(node.Pos(), "Rasta", "Set", this.translate(node.Lhs[0]), this.translate(bin))
+			break
 		}
-		genRhs := &Generator{
-			Program: make(chan MExpr),
+		if this.Failures {
+			if set := this.errorAssign(node); set != nil {
+				this.Program <- set
+				break
+			}
 		}
+		if this.Stdlib != nil {
+			if set := this.stdAssign(node); set != nil {
+				this.Program <- set
+				break
+			}
+		}
+		genLhs := this.child()
+		genRhs := this.child()
 		defer close(genLhs.Program)
 		defer close(genRhs.Program)
 		go func() {
@@ -726,9 +920,17 @@ func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
 			}
 		}
 	case *ast.BinaryExpr:
-		gen := &Generator{
-			Program: make(chan MExpr),
+		if this.Failures {
+			if failed := this.errorCompare(node); failed != nil {
+				this.Program <- failed
+				break
+			}
 		}
+		if head, ok := binaryHeads[node.Op]; ok && this.Operators {
+			this.Program <- this.operation(node.Pos(), head, node.X, node.Y)
+			break
+		}
+		gen := this.child()
 		defer close(gen.Program)
 		go func() {
 			gen.Program <- &MExprString{
@@ -760,9 +962,11 @@ func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
 			Arguments: args,
 		}
 	case *ast.UnaryExpr:
-		gen := &Generator{
-			Program: make(chan MExpr),
+		if head, ok := unaryHeads[node.Op]; ok && this.Operators {
+			this.Program <- this.operation(node.Pos(), head, node.X)
+			break
 		}
+		gen := this.child()
 		defer close(gen.Program)
 		go func() {
 			gen.Visit(node.X)
@@ -790,9 +994,7 @@ func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
 			Arguments: args,
 		}
 	case *ast.IfStmt:
-		gen := &Generator{
-			Program: make(chan MExpr),
-		}
+		gen := this.child()
 		defer close(gen.Program)
 		go func() {
 			gen.Visit(node.Cond)
@@ -815,7 +1017,7 @@ func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
 			<-gen.Program,
 			<-gen.Program,
 		}
-		this.Program <- &MExprNormal{
+		this.Program <- this.initScope(node.Init, &MExprNormal{
 			MExprBase: MExprBase{
 				Position: node.Pos(),
 			},
@@ -827,20 +1029,76 @@ func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
 				Name:    "If",
 			},
 			Arguments: args,
+		})
+	case *ast.ForStmt:
+		var cond MExpr = newPosSymbol(node.Pos(), "System", "True")
+		if node.Cond != nil {
+			cond = this.translate(node.Cond)
 		}
-	case *ast.ExprStmt:
-		this.Program <- &MExprSymbol{
-			MExprBase: MExprBase{
-				Position: node.Pos(),
-			},
-			Context: "Rasta",
-			Name:    "ExprStmt",
+		// For has the init statement, and its Module its locals.
+		this.Program <- this.scope(node.Pos(), blockLocals([]ast.Stmt{node.Init}), newPosNormal(node.Pos(), "System", "For",
+			this.optional(node.Pos(), node.Init),
+			cond,
+			this.optional(node.Pos(), node.Post),
+			this.translate(node.Body),
+		))
+	case *ast.RangeStmt:
+		// Rasta`Range[key, value, x, body], with Null for
+		// an omitted key or value.
+		loop := newPosNormal(node.Pos(), "Rasta", "Range",
+			this.optional(node.Pos(), node.Key),
+			this.optional(node.Pos(), node.Value),
+			this.translate(node.X),
+			this.translate(node.Body),
+		)
+		var locals []*ast.Ident
+		if node.Tok == token.DEFINE {
+			locals = identList(nil, []ast.Expr{node.Key, node.Value})
 		}
+		this.Program <- this.scope(node.Pos(), locals, loop)
+	case *ast.IncDecStmt:
+		name := "Increment"
+		if node.Tok == token.DEC {
+			name = "Decrement"
+		}
+		this.Program <- newPosNormal(node.Pos(), "System", name, this.translate(node.X))
+	case *ast.ExprStmt:
+		this.Visit(node.X)
 	case *ast.ReturnStmt:
-		var args []MExpr
-		gen := &Generator{
-			Program: make(chan MExpr),
+		if len(this.results) > 0 {
+			if len(node.Results) == 0 {
+				// A bare return returns the named results.
+				this.Program <- this.returnResults(node.Pos())
+				break
+			}
+			if this.deferring {
+				// The deferred calls may change the named results:
+				// set them, and lowerDefers returns them after the
+				// calls have run.
+				set := newPosNormal(node.Pos(), "Rasta", "Set", this.resultList(node.Pos()), this.translate(node.Results[0]))
+				if len(node.Results) > 1 {
+					values := make([]MExpr, len(node.Results))
+					for i, res := range node.Results {
+						values[i] = this.translate(res)
+					}
+					set.Arguments[1] = newPosNormal(node.Pos(), "System", "List", values...)
+				}
+				this.Program <- newPosNormal(node.Pos(), "System", "CompoundExpression", set, this.returnResults(node.Pos()))
+				break
+			}
 		}
+		if this.Failures && this.errorResults > 0 {
+			if ret := this.errorReturn(node); ret != nil {
+				this.Program <- ret
+				break
+			}
+		}
+		if this.Failures {
+			this.Program <- this.plainReturn(node)
+			break
+		}
+		var args []MExpr
+		gen := this.child()
 		defer close(gen.Program)
 		go func() {
 			for _, res := range node.Results {
@@ -896,6 +1154,17 @@ func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
 				},
 				Value: ii,
 			}
+		} else if node.Kind == token.STRING {
+			str, err := strconv.Unquote(node.Value)
+			if err != nil {
+				panic(spew.Sdump("Cannot parse string value ", node.Value))
+			}
+			this.Program <- &MExprString{
+				MExprBase: MExprBase{
+					Position: node.Pos(),
+				},
+				Value: quoteString(str),
+			}
 		} else {
 			this.Program <- &MExprString{
 				MExprBase: MExprBase{
@@ -912,6 +1181,74 @@ func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
 			Context: "Rasta",
 			Name:    "CompositeLit",
 		}
+	case *ast.SliceExpr:
+		if this.CMemory {
+			if ptr, elt, n, ok := this.arrayView(node); ok {
+				this.Program <- this.memoryExpr(node.Pos(), "CArrayView", []ast.Expr{ptr, elt, n}, "Borrowed")
+				break
+			}
+		}
+		// Rasta`SliceExpr[x, low, high, max], with Null
+		// for the indices left out.
+		args := []MExpr{this.translate(node.X), this.optional(node.Lbrack, node.Low), this.optional(node.Lbrack, node.High)}
+		if node.Slice3 {
+			args = append(args, this.translate(node.Max))
+		}
+		this.Program <- newPosNormal(node.Pos(), "Rasta", "SliceExpr", args...)
+	case *ast.IndexListExpr:
+		this.Program <- this.instantiate(node.Pos(), node.X, node.Indices)
+	case *ast.IndexExpr:
+		// Without type information, x[i] is taken to be an
+		// instantiation only if i can only be a type.
+		if isTypeExpr(node.Index) || this.Info != nil && this.Info.Types[node.Index].IsType() {
+			this.Program <- this.instantiate(node.Pos(), node.X, []ast.Expr{node.Index})
+			break
+		}
+		this.Program <- newPosNormal(node.Pos(), "Rasta", "Index", this.translate(node.X), this.translate(node.Index))
+	case *ast.InterfaceType:
+		var elems []MExpr
+		for _, field := range node.Methods.List {
+			if len(field.Names) == 0 {
+				elems = append(elems, this.typeTerm(field.Type))
+				continue
+			}
+			for _, name := range field.Names {
+				elems = append(elems, newPosNormal(name.Pos(), "Rasta", "Method", this.translate(name), this.signature(field.Type.(*ast.FuncType))))
+			}
+		}
+		this.Program <- newPosNormal(node.Pos(), "Rasta", "Interface", newPosNormal(node.Pos(), "System", "List", elems...))
+	case *ast.StructType:
+		var fields []MExpr
+		for _, field := range node.Fields.List {
+			if len(field.Names) == 0 {
+				fields = append(fields, newPosNormal(field.Pos(), "Rasta", "Embed", this.translate(field.Type)))
+				continue
+			}
+			for _, name := range field.Names {
+				fields = append(fields, newPosNormal(name.Pos(), "Rasta", "Field", this.translate(name), this.translate(field.Type)))
+			}
+		}
+		this.Program <- newPosNormal(node.Pos(), "Rasta", "Struct", newPosNormal(node.Pos(), "System", "List", fields...))
+	case *ast.ArrayType:
+		switch {
+		case node.Len == nil:
+			this.Program <- newPosNormal(node.Pos(), "Rasta", "Slice", this.translate(node.Elt))
+		case isEllipsis(node.Len):
+			this.Program <- newPosNormal(node.Pos(), "Rasta", "Array", newPosNormal(node.Len.Pos(), "System", "Automatic"), this.translate(node.Elt))
+		default:
+			this.Program <- newPosNormal(node.Pos(), "Rasta", "Array", this.translate(node.Len), this.translate(node.Elt))
+		}
+	case *ast.MapType:
+		this.Program <- newPosNormal(node.Pos(), "Rasta", "Map", this.translate(node.Key), this.translate(node.Value))
+	case *ast.ChanType:
+		head := "Chan"
+		switch node.Dir {
+		case ast.SEND:
+			head = "SendChan"
+		case ast.RECV:
+			head = "ReceiveChan"
+		}
+		this.Program <- newPosNormal(node.Pos(), "Rasta", head, this.translate(node.Value))
 	default:
 		pp.Println(node)
 		panic(node)
@@ -921,6 +1258,436 @@ func (this *Generator) Visit(anode ast.Node) (w ast.Visitor) {
 	return this
 }
 
+// translate returns the translation of node, which
+// must be one that Visit translates to a single MExpr.
+func (this *Generator) translate(node ast.Node) MExpr {
+	gen := this.child()
+	defer close(gen.Program)
+	go gen.Visit(node)
+	return <-gen.Program
+}
+
+// packageRef returns the package that the selector x.Sel refers into,
+// or nil if x is not an imported package.  The pseudo-package C,
+// whose names are translated as Rasta`C, is not counted.
+func (this *Generator) packageRef(sel *ast.SelectorExpr) *types.Package {
+	if this.Info == nil {
+		return nil
+	}
+	id, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return nil
+	}
+	pkgName, ok := this.Info.Uses[id].(*types.PkgName)
+	if !ok || pkgName.Imported().Path() == "C" {
+		return nil
+	}
+	return pkgName.Imported()
+}
+
+// isC reports whether x refers to the pseudo-package C, rather than
+// to something else named C.  With types, C must denote the import
+// "C"; without, it must not have been declared in the file, as the
+// parser finds.
+func (this *Generator) isC(x ast.Expr) bool {
+	id, ok := x.(*ast.Ident)
+	if !ok || id.Name != "C" {
+		return false
+	}
+	if this.Info != nil {
+		if obj := this.Info.Uses[id]; obj != nil {
+			pkgName, ok := obj.(*types.PkgName)
+			return ok && pkgName.Imported().Path() == "C"
+		}
+	}
+	return id.Obj == nil
+}
+
+// selection returns what the selector sel selects, if it is a field
+// or method rather than a qualified name, and its types are known.
+func (this *Generator) selection(sel *ast.SelectorExpr) *types.Selection {
+	if this.Info == nil {
+		return nil
+	}
+	return this.Info.Selections[sel]
+}
+
+// isConversion reports whether call is known to be a type conversion T(x).
+func (this *Generator) isConversion(call *ast.CallExpr) bool {
+	if this.Info == nil || len(call.Args) != 1 {
+		return false
+	}
+	return this.Info.Types[call.Fun].IsType()
+}
+
+// packageName qualifies the names of types from other packages
+// by the package name, as in Go source.
+func packageName(pkg *types.Package) string {
+	return pkg.Name()
+}
+
+// newPosNormal returns context`name[args] positioned at pos.
+func newPosNormal(pos token.Pos, context, name string, args ...MExpr) *MExprNormal {
+	return &MExprNormal{
+		MExprBase: MExprBase{
+			Position: pos,
+		},
+		Hd: &MExprSymbol{
+			MExprBase: MExprBase{
+				Position: pos,
+			},
+			Context: context,
+			Name:    name,
+		},
+		Arguments: args,
+	}
+}
+
+// newPosSymbol returns context`name positioned at pos.
+func newPosSymbol(pos token.Pos, context, name string) *MExprSymbol {
+	return &MExprSymbol{
+		MExprBase: MExprBase{
+			Position: pos,
+		},
+		Context: context,
+		Name:    name,
+	}
+}
+
+// generic wraps decl, the translation of a function or type declared
+// with the type parameters params, as
+// Rasta`Generic[{Rasta`TypeParameter[T, constraint], ...}, decl].
+// A declaration without type parameters is left alone.
+func (this *Generator) generic(params *ast.FieldList, decl MExpr) MExpr {
+	if params.NumFields() == 0 {
+		return decl
+	}
+	var tparams []MExpr
+	for _, field := range params.List {
+		constraint := this.typeTerm(field.Type)
+		for _, name := range field.Names {
+			tparams = append(tparams, newPosNormal(name.Pos(), "Rasta", "TypeParameter", this.translate(name), constraint))
+		}
+	}
+	return newPosNormal(params.Pos(), "Rasta", "Generic", newPosNormal(params.Pos(), "System", "List", tparams...), decl)
+}
+
+// typeTerm translates a constraint or an element of a constraint
+// interface, in which a | b is the union Rasta`Union[a, b] of its
+// terms and ~T is Rasta`Underlying[T], the types whose underlying type is T.
+func (this *Generator) typeTerm(x ast.Expr) MExpr {
+	switch x := x.(type) {
+	case *ast.ParenExpr:
+		return this.typeTerm(x.X)
+	case *ast.UnaryExpr:
+		if x.Op == token.TILDE {
+			return newPosNormal(x.Pos(), "Rasta", "Underlying", this.translate(x.X))
+		}
+	case *ast.BinaryExpr:
+		if x.Op == token.OR {
+			var terms []MExpr
+			for _, t := range []MExpr{this.typeTerm(x.X), this.typeTerm(x.Y)} {
+				if u, ok := t.(*MExprNormal); ok && isSymbol(u.Hd, "Rasta", "Union") {
+					terms = append(terms, u.Arguments...)
+				} else {
+					terms = append(terms, t)
+				}
+			}
+			return newPosNormal(x.Pos(), "Rasta", "Union", terms...)
+		}
+	}
+	return this.translate(x)
+}
+
+// signature returns Rasta`Signature[{params}, {results}] for the
+// function type ft, with one type for each parameter and result,
+// and Rasta`Variadic[T] for a final ...T.
+func (this *Generator) signature(ft *ast.FuncType) MExpr {
+	types := func(fields *ast.FieldList) []MExpr {
+		var ts []MExpr
+		if fields == nil {
+			return ts
+		}
+		for _, field := range fields.List {
+			var t MExpr
+			if e, ok := field.Type.(*ast.Ellipsis); ok {
+				t = newPosNormal(e.Pos(), "Rasta", "Variadic", this.translate(e.Elt))
+			} else {
+				t = this.translate(field.Type)
+			}
+			for i := 0; i < len(field.Names) || i == 0; i++ {
+				ts = append(ts, t)
+			}
+		}
+		return ts
+	}
+	return newPosNormal(ft.Pos(), "Rasta", "Signature",
+		newPosNormal(ft.Pos(), "System", "List", types(ft.Params)...),
+		newPosNormal(ft.Pos(), "System", "List", types(ft.Results)...))
+}
+
+// instantiate returns Rasta`Instantiate[x, {types}] for the
+// instantiation of the generic function or type x with types.
+func (this *Generator) instantiate(pos token.Pos, x ast.Expr, targs []ast.Expr) MExpr {
+	args := make([]MExpr, len(targs))
+	for i, t := range targs {
+		args[i] = this.translate(t)
+	}
+	return newPosNormal(pos, "Rasta", "Instantiate", this.translate(x), newPosNormal(pos, "System", "List", args...))
+}
+
+// function returns a Generator for translating the function of type
+// ft with the body body.
+func (this *Generator) function(ft *ast.FuncType, body *ast.BlockStmt) *Generator {
+	gen := this.child()
+	gen.errorResults = this.errorResultCount(ft)
+	gen.results = nil
+	if ft.Results != nil && len(ft.Results.List[0].Names) > 0 {
+		for _, field := range ft.Results.List {
+			gen.results = append(gen.results, field.Names...)
+		}
+	}
+	gen.deferring = this.LowerDefer && hasDefer(body)
+	return gen
+}
+
+// functionBody finishes body, the translation of the body node of the
+// function of type ft that this translates: it lowers its deferred
+// calls, and binds its named results in a Module around it to their
+// zero values, Rasta`Zero[type], so that a bare return and deferred
+// calls see them.
+func (this *Generator) functionBody(ft *ast.FuncType, node *ast.BlockStmt, body MExpr) MExpr {
+	if node == nil {
+		return body
+	}
+	if this.deferring {
+		var ret MExpr
+		if len(this.results) > 0 {
+			ret = this.returnResults(node.Rbrace)
+		}
+		body = lowerDefers(node.Pos(), body, ret)
+	}
+	if len(this.results) == 0 {
+		return body
+	}
+	var inits []MExpr
+	for _, field := range ft.Results.List {
+		zero := newPosNormal(field.Type.Pos(), "Rasta", "Zero", this.translate(field.Type))
+		for _, id := range field.Names {
+			if id.Name != "_" {
+				inits = append(inits, newPosNormal(id.Pos(), "System", "Set", this.name(id), zero))
+			}
+		}
+	}
+	return newPosNormal(node.Pos(), "System", "Module", newPosNormal(node.Pos(), "System", "List", inits...), body)
+}
+
+// resultList returns the named results of the function that this
+// translates, as a List if there are several.
+func (this *Generator) resultList(pos token.Pos) MExpr {
+	if len(this.results) == 1 {
+		return this.name(this.results[0])
+	}
+	names := make([]MExpr, len(this.results))
+	for i, id := range this.results {
+		names[i] = this.name(id)
+	}
+	return newPosNormal(pos, "System", "List", names...)
+}
+
+// returnResults returns the translation of a return at pos of the
+// named results of the function that this translates.
+func (this *Generator) returnResults(pos token.Pos) MExpr {
+	results := make([]ast.Expr, len(this.results))
+	for i, id := range this.results {
+		results[i] = id
+	}
+	gen := this.child()
+	gen.results = nil
+	defer close(gen.Program)
+	return gen.translate(&ast.ReturnStmt{Return: pos, Results: results})
+}
+
+// scope wraps body, the translation of a block or statement in which
+// the locals are declared, as Module[{locals}, body], so that they are
+// local to it as in Go.  A nested block gets its own Module, which
+// shadows the symbols of the enclosing ones of the same name.
+func (this *Generator) scope(pos token.Pos, locals []*ast.Ident, body MExpr) MExpr {
+	if len(locals) == 0 {
+		return body
+	}
+	syms := make([]MExpr, len(locals))
+	for i, id := range locals {
+		syms[i] = this.name(id)
+	}
+	return newPosNormal(pos, "System", "Module", newPosNormal(pos, "System", "List", syms...), body)
+}
+
+// initScope returns the translation of a statement stmt with the
+// init statement init: CompoundExpression[init, stmt], in a Module
+// for what init declares.  Without init, it is stmt.
+func (this *Generator) initScope(init ast.Stmt, stmt MExpr) MExpr {
+	if init == nil {
+		return stmt
+	}
+	return this.scope(init.Pos(), blockLocals([]ast.Stmt{init}),
+		newPosNormal(init.Pos(), "System", "CompoundExpression", this.translate(init), stmt))
+}
+
+// optional translates node, or returns Null at pos if it is nil.
+func (this *Generator) optional(pos token.Pos, node ast.Node) MExpr {
+	if node == nil {
+		return newPosSymbol(pos, "System", "Null")
+	}
+	return this.translate(node)
+}
+
+// blockLocals returns the variables and constants that stmts
+// declare in their block, each name once.
+func blockLocals(stmts []ast.Stmt) []*ast.Ident {
+	var locals []*ast.Ident
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *ast.AssignStmt:
+			if stmt.Tok == token.DEFINE {
+				locals = identList(locals, stmt.Lhs)
+			}
+		case *ast.DeclStmt:
+			decl, ok := stmt.Decl.(*ast.GenDecl)
+			if !ok || decl.Tok != token.VAR && decl.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				for _, name := range spec.(*ast.ValueSpec).Names {
+					locals = identList(locals, []ast.Expr{name})
+				}
+			}
+		}
+	}
+	return locals
+}
+
+// identList appends to locals the identifiers among xs that are
+// not blank or already in locals.
+func identList(locals []*ast.Ident, xs []ast.Expr) []*ast.Ident {
+next:
+	for _, x := range xs {
+		id, ok := x.(*ast.Ident)
+		if !ok || id.Name == "_" {
+			continue
+		}
+		for _, l := range locals {
+			if l.Name == id.Name {
+				continue next
+			}
+		}
+		locals = append(locals, id)
+	}
+	return locals
+}
+
+// binaryHeads maps Go's binary operators to the heads that
+// Generator.Operators translates them to.  The heads follow Go's
+// semantics: Rasta`Quo truncates integer quotients towards zero,
+// Rasta`Rem takes the sign of the dividend, the shifts and bitwise
+// operators work on the operands' width, and Rasta`LogicalAnd and
+// Rasta`LogicalOr evaluate their second operand only when needed.
+var binaryHeads = map[token.Token]string{
+	token.ADD:     "Add",
+	token.SUB:     "Sub",
+	token.MUL:     "Mul",
+	token.QUO:     "Quo",
+	token.REM:     "Rem",
+	token.AND:     "BitAnd",
+	token.OR:      "BitOr",
+	token.XOR:     "BitXor",
+	token.SHL:     "Shl",
+	token.SHR:     "Shr",
+	token.AND_NOT: "AndNot",
+	token.LAND:    "LogicalAnd",
+	token.LOR:     "LogicalOr",
+	token.EQL:     "Equal",
+	token.NEQ:     "NotEqual",
+	token.LSS:     "Less",
+	token.LEQ:     "LessEqual",
+	token.GTR:     "Greater",
+	token.GEQ:     "GreaterEqual",
+}
+
+// assignOp returns the binary operator of the compound assignment
+// operator tok, such as + for +=, or token.ILLEGAL for = and :=.
+func assignOp(tok token.Token) token.Token {
+	if token.ADD_ASSIGN <= tok && tok <= token.AND_NOT_ASSIGN {
+		return tok + (token.ADD - token.ADD_ASSIGN)
+	}
+	return token.ILLEGAL
+}
+
+// unaryHeads maps Go's unary operators to the heads that
+// Generator.Operators translates them to.  ^x is Rasta`BitNot,
+// &x is Rasta`AddressOf and <-ch is Rasta`Receive.
+var unaryHeads = map[token.Token]string{
+	token.ADD:   "Plus",
+	token.SUB:   "Neg",
+	token.NOT:   "Not",
+	token.XOR:   "BitNot",
+	token.AND:   "AddressOf",
+	token.ARROW: "Receive",
+}
+
+// operation returns Rasta`head[operands...].  When the type of the first
+// operand is known, which for all but shifts is that of the others too,
+// it is given as a last argument "Type" -> "type", so that, say,
+// Rasta`Quo can tell integer from floating-point division and
+// Rasta`Add string concatenation from addition.
+func (this *Generator) operation(pos token.Pos, head string, operands ...ast.Expr) MExpr {
+	var args []MExpr
+	for _, x := range operands {
+		args = append(args, this.translate(x))
+	}
+	if this.Info != nil {
+		if tv, ok := this.Info.Types[operands[0]]; ok && tv.Type != nil {
+			args = append(args, newRule(&MExprString{Value: "Type"}, &MExprString{Value: types.TypeString(tv.Type, packageName)}))
+		}
+	}
+	return newPosNormal(pos, "Rasta", head, args...)
+}
+
+// predeclaredTypes lists the names of Go's predeclared types.
+var predeclaredTypes = map[string]bool{
+	"any": true, "bool": true, "byte": true, "comparable": true,
+	"complex64": true, "complex128": true, "error": true,
+	"float32": true, "float64": true,
+	"int": true, "int8": true, "int16": true, "int32": true, "int64": true,
+	"rune": true, "string": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true, "uintptr": true,
+}
+
+// isTypeExpr reports whether x can only denote a type.
+func isTypeExpr(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.Ident:
+		// An identifier resolved in the file says what it names.
+		return predeclaredTypes[x.Name] || x.Obj != nil && x.Obj.Kind == ast.Typ
+	case *ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.FuncType, *ast.InterfaceType, *ast.StructType:
+		return true
+	case *ast.IndexListExpr:
+		return true
+	}
+	return false
+}
+
+func isEllipsis(x ast.Expr) bool {
+	_, ok := x.(*ast.Ellipsis)
+	return ok
+}
+
+// isSymbol reports whether e is the symbol context`name.
+func isSymbol(e MExpr, context, name string) bool {
+	sym, ok := e.(*MExprSymbol)
+	return ok && sym.Context == context && sym.Name == name
+}
+
 const code = `
 package cgo
 
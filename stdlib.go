@@ -0,0 +1,662 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// With Generator.Stdlib, calls of the standard library functions that
+// a StdMapping knows are translated to the Wolfram functions that do
+// the same, or as near as the kernel comes, so that the translation
+// does not depend on a Go runtime: strings.Join(a, sep) is
+// StringRiffle[a, sep], strconv.Itoa(i) is ToString[i], and
+// fmt.Sprintf("%d items", n) is
+// TemplateApply[StringTemplate["`1` items"], {n}].  A function that is
+// not called but used as a value is the Function of its template, and
+// a constant, such as math.Pi, is its template.  Functions with results
+// (T, error) map to Wolfram functions that return T or a Failure, as
+// with Generator.Failures, and n, err := f(x) splits the one value
+// with Rasta`Results even without it.
+//
+// The names in imported packages that it does not know, and the
+// imports of packages that it knows nothing of, are left as they are
+// and recorded, to be reported by Unmapped.
+
+// A StdMapping maps the functions and constants of Go packages to
+// templates of Wolfram expressions.  It is read from lines
+//
+//	path.Name template
+//
+// such as
+//
+//	strings.Join StringRiffle[#1, #2]
+//
+// in which the template is in InputForm, restricted to symbols,
+// strings, integers, lists, rules and applications f[args], and #n
+// stands for the n-th argument of the call, ##n for those from the n-th
+// on, and %n for the n-th, which must be a constant Go format string,
+// as a StringTemplate string.  Blank lines and lines starting with //
+// are ignored.
+type StdMapping struct {
+	funcs   map[string]MExpr  // templates by path.Name
+	pkgs    map[string]bool   // import paths with templates
+	imports map[string]string // import paths by package name, without types
+
+	mu       sync.Mutex
+	unmapped map[string]bool
+}
+
+// NewStdMapping returns a mapping with the templates of stdlibTable.
+func NewStdMapping() *StdMapping {
+	m := &StdMapping{
+		funcs:    make(map[string]MExpr),
+		pkgs:     make(map[string]bool),
+		imports:  make(map[string]string),
+		unmapped: make(map[string]bool),
+	}
+	if err := m.Load(strings.NewReader(stdlibTable)); err != nil {
+		panic("stdlibTable: " + err.Error())
+	}
+	return m
+}
+
+// Load adds the templates read from r to m, replacing
+// those that m already has for the same names.
+func (m *StdMapping) Load(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "//") {
+			continue
+		}
+		i := strings.IndexAny(text, " \t")
+		if i < 0 {
+			return fmt.Errorf("line %d: no template for %s", line, text)
+		}
+		key := text[:i]
+		dot := strings.LastIndex(key, ".")
+		if dot <= 0 || dot == len(key)-1 {
+			return fmt.Errorf("line %d: %s is not of the form path.Name", line, key)
+		}
+		t, err := parseTemplate(strings.TrimSpace(text[i:]))
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		m.funcs[key] = t
+		m.pkgs[key[:dot]] = true
+	}
+	return s.Err()
+}
+
+// AddImports records the packages that f imports, so that they can be
+// told apart from other names without types, and records those that m
+// knows nothing of as unmapped.
+func (m *StdMapping) AddImports(f *ast.File) {
+	for _, imp := range f.Imports {
+		p, err := strconv.Unquote(imp.Path.Value)
+		if err != nil || p == "C" || p == "unsafe" {
+			continue
+		}
+		name := path.Base(p)
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		if name != "_" && name != "." {
+			m.imports[name] = p
+		}
+		if !m.pkgs[p] {
+			m.record(p)
+		}
+	}
+}
+
+// Unmapped returns the import paths, and the path.Name of the names in
+// imported packages, that m had no template for, sorted.
+func (m *StdMapping) Unmapped() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.unmapped))
+	for name := range m.unmapped {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *StdMapping) record(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.unmapped[name] = true
+}
+
+// stdKey returns path.Name for the selector x.Name of a name in an
+// imported package, other than C and unsafe.
+func (this *Generator) stdKey(sel *ast.SelectorExpr) (string, bool) {
+	var p string
+	if this.Info != nil {
+		pkg := this.packageRef(sel)
+		if pkg == nil {
+			return "", false
+		}
+		p = pkg.Path()
+	} else {
+		id, ok := sel.X.(*ast.Ident)
+		if !ok || id.Obj != nil || this.Stdlib.imports[id.Name] == "" {
+			return "", false
+		}
+		p = this.Stdlib.imports[id.Name]
+	}
+	if p == "unsafe" {
+		return "", false
+	}
+	return p + "." + sel.Sel.Name, true
+}
+
+// stdCall returns the instance of the template for the function that
+// call calls, or nil if there is none or its arguments do not fit it.
+func (this *Generator) stdCall(call *ast.CallExpr) MExpr {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return nil
+	}
+	key, ok := this.stdKey(sel)
+	if !ok || this.Stdlib.funcs[key] == nil {
+		return nil
+	}
+	xs, ok := this.fillTemplate(this.Stdlib.funcs[key], call.Pos(), call.Args)
+	if !ok || len(xs) != 1 {
+		return nil
+	}
+	return xs[0]
+}
+
+// stdAssign translates x, err = f() and its := form, where f has a
+// template, or returns nil if node is not such an assignment.  The
+// template returns one value, T or a Failure for f's (T, error), which
+// Rasta`Results splits back into the values, as with Generator.Failures.
+func (this *Generator) stdAssign(node *ast.AssignStmt) MExpr {
+	if len(node.Lhs) < 2 || len(node.Rhs) != 1 {
+		return nil
+	}
+	call, ok := node.Rhs[0].(*ast.CallExpr)
+	if !ok {
+		return nil
+	}
+	rhs := this.stdCall(call)
+	if rhs == nil {
+		return nil
+	}
+	return this.resultsAssign(node, rhs)
+}
+
+// stdValue returns the translation of sel, if it is a name in an
+// imported package with a template that does not need the arguments of
+// a call, and nil otherwise.  It records the names without templates.
+func (this *Generator) stdValue(sel *ast.SelectorExpr) MExpr {
+	key, ok := this.stdKey(sel)
+	if !ok {
+		return nil
+	}
+	t := this.Stdlib.funcs[key]
+	if t == nil {
+		this.Stdlib.record(key)
+		return nil
+	}
+	isFunc := false
+	if this.Info != nil {
+		_, isFunc = this.Info.Uses[sel.Sel].(*types.Func)
+	}
+	if !isFunc && !hasArgs(t) {
+		return t
+	}
+	body, ok := slots(t)
+	if !ok {
+		this.Stdlib.record(key)
+		return nil
+	}
+	return newPosNormal(sel.Pos(), "System", "Function", body)
+}
+
+// fillTemplate returns t with the arguments args of a call in place of
+// #n, ##n and %n, as a sequence of one expression unless t is ##n, or
+// false if args do not fit t.
+func (this *Generator) fillTemplate(t MExpr, pos token.Pos, args []ast.Expr) ([]MExpr, bool) {
+	switch t := t.(type) {
+	case *stdArg:
+		if t.Kind == '@' {
+			var xs []MExpr
+			for i := t.N - 1; i < len(args); i++ {
+				xs = append(xs, this.translate(args[i]))
+			}
+			return xs, true
+		}
+		if t.N > len(args) {
+			return nil, false
+		}
+		if t.Kind == '%' {
+			format, ok := this.stringConstant(args[t.N-1])
+			if !ok {
+				return nil, false
+			}
+			template, ok := goTemplate(format)
+			if !ok {
+				return nil, false
+			}
+			return []MExpr{&MExprString{
+				MExprBase: MExprBase{
+					Position: args[t.N-1].Pos(),
+				},
+				Value: quoteString(template),
+			}}, true
+		}
+		return []MExpr{this.translate(args[t.N-1])}, true
+	case *MExprNormal:
+		hd, ok := this.fillTemplate(t.Hd, pos, args)
+		if !ok || len(hd) != 1 {
+			return nil, false
+		}
+		var xs []MExpr
+		for _, arg := range t.Arguments {
+			x, ok := this.fillTemplate(arg, pos, args)
+			if !ok {
+				return nil, false
+			}
+			xs = append(xs, x...)
+		}
+		return []MExpr{&MExprNormal{
+			MExprBase: MExprBase{
+				Position: pos,
+			},
+			Hd:        hd[0],
+			Arguments: xs,
+		}}, true
+	}
+	return []MExpr{t}, true
+}
+
+// stringConstant returns the value of x if it is a constant string.
+func (this *Generator) stringConstant(x ast.Expr) (string, bool) {
+	if this.Info != nil {
+		if tv, ok := this.Info.Types[x]; ok && tv.Value != nil && tv.Value.Kind() == constant.String {
+			return constant.StringVal(tv.Value), true
+		}
+	}
+	lit, ok := x.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	s, err := strconv.Unquote(lit.Value)
+	return s, err == nil
+}
+
+// goTemplate converts the Go format string format to a StringTemplate
+// string, in which the n-th argument is `n`.  It fails unless every
+// verb is %v, %s or %d, without flags, width or precision, which
+// ToString formats the same, and unless format is free of the
+// backquotes and <* that StringTemplate would take for its own.
+func goTemplate(format string) (string, bool) {
+	if strings.Contains(format, "`") || strings.Contains(format, "<*") {
+		return "", false
+	}
+	var b strings.Builder
+	arg := 1
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i == len(format) {
+			return "", false
+		}
+		if format[i] == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		if format[i] == '[' {
+			end := strings.IndexByte(format[i:], ']')
+			if end < 0 {
+				return "", false
+			}
+			n, err := strconv.Atoi(format[i+1 : i+end])
+			if err != nil || n < 1 {
+				return "", false
+			}
+			arg = n
+			i += end + 1
+			if i == len(format) {
+				return "", false
+			}
+		}
+		switch format[i] {
+		case 'v', 's', 'd':
+			fmt.Fprintf(&b, "`%d`", arg)
+			arg++
+		default:
+			return "", false
+		}
+	}
+	return b.String(), true
+}
+
+// A stdArg stands in a template for arguments of the call.
+type stdArg struct {
+	MExprBase
+	Kind byte // '#' for the N-th argument, '@' for those from the N-th on, '%' for the N-th as a template
+	N    int
+}
+
+func (this *stdArg) Head() MExpr {
+	if this.Kind == '@' {
+		return newSymbol("System", "SlotSequence")
+	}
+	return newSymbol("System", "Slot")
+}
+func (*stdArg) Length() int {
+	return 0
+}
+func (this *stdArg) String() string {
+	switch this.Kind {
+	case '@':
+		return "##" + strconv.Itoa(this.N)
+	case '%':
+		return "%" + strconv.Itoa(this.N)
+	}
+	return "#" + strconv.Itoa(this.N)
+}
+
+// hasArgs reports whether t refers to arguments of the call.
+func hasArgs(t MExpr) bool {
+	switch t := t.(type) {
+	case *stdArg:
+		return true
+	case *MExprNormal:
+		if hasArgs(t.Hd) {
+			return true
+		}
+		for _, arg := range t.Arguments {
+			if hasArgs(arg) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// slots returns t with #n and ##n as the Slot[n] and SlotSequence[n]
+// of a Function, or false if t has a %n, which needs a constant.
+func slots(t MExpr) (MExpr, bool) {
+	switch t := t.(type) {
+	case *stdArg:
+		switch t.Kind {
+		case '#':
+			return newNormal(newSymbol("System", "Slot"), &MExprInteger{Value: t.N}), true
+		case '@':
+			return newNormal(newSymbol("System", "SlotSequence"), &MExprInteger{Value: t.N}), true
+		}
+		return nil, false
+	case *MExprNormal:
+		hd, ok := slots(t.Hd)
+		if !ok {
+			return nil, false
+		}
+		args := make([]MExpr, len(t.Arguments))
+		for i, arg := range t.Arguments {
+			if args[i], ok = slots(arg); !ok {
+				return nil, false
+			}
+		}
+		return newNormal(hd, args...), true
+	}
+	return t, true
+}
+
+// parseTemplate parses the template s of a StdMapping.
+func parseTemplate(s string) (MExpr, error) {
+	p := &templateParser{s: s}
+	t, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos < len(p.s) {
+		return nil, fmt.Errorf("unexpected %q in template %s", p.s[p.pos:], s)
+	}
+	return t, nil
+}
+
+type templateParser struct {
+	s   string
+	pos int
+}
+
+func (p *templateParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// peek skips spaces and reports whether the input continues with tok,
+// which it then also skips.
+func (p *templateParser) peek(tok string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.s[p.pos:], tok) {
+		p.pos += len(tok)
+		return true
+	}
+	return false
+}
+
+func (p *templateParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("template %s: at %d: %s", p.s, p.pos, fmt.Sprintf(format, args...))
+}
+
+// expr parses primary[args]...[args] and primary -> expr.
+func (p *templateParser) expr() (MExpr, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("[") {
+		args, err := p.list("]")
+		if err != nil {
+			return nil, err
+		}
+		x = newNormal(x, args...)
+	}
+	if p.peek("->") {
+		y, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		x = newRule(x, y)
+	}
+	return x, nil
+}
+
+// list parses a comma-separated list of expressions up to end.
+func (p *templateParser) list(end string) ([]MExpr, error) {
+	var xs []MExpr
+	if p.peek(end) {
+		return xs, nil
+	}
+	for {
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		xs = append(xs, x)
+		if p.peek(end) {
+			return xs, nil
+		}
+		if !p.peek(",") {
+			return nil, p.errorf("expected , or %s", end)
+		}
+	}
+}
+
+func (p *templateParser) primary() (MExpr, error) {
+	p.skipSpace()
+	if p.pos == len(p.s) {
+		return nil, p.errorf("unexpected end")
+	}
+	switch c := p.s[p.pos]; {
+	case c == '{':
+		p.pos++
+		args, err := p.list("}")
+		if err != nil {
+			return nil, err
+		}
+		return newList(args...), nil
+	case c == '"':
+		start := p.pos + 1
+		for p.pos++; p.pos < len(p.s) && p.s[p.pos] != '"'; p.pos++ {
+			if p.s[p.pos] == '\\' {
+				p.pos++
+			}
+		}
+		if p.pos >= len(p.s) {
+			return nil, p.errorf("unterminated string")
+		}
+		p.pos++
+		// Kept as written, escapes and all, as MExprString prints it.
+		return &MExprString{Value: p.s[start : p.pos-1]}, nil
+	case c == '#' || c == '%':
+		kind := c
+		p.pos++
+		if c == '#' && p.pos < len(p.s) && p.s[p.pos] == '#' {
+			kind = '@'
+			p.pos++
+		}
+		n, ok := p.number()
+		if !ok || n < 1 {
+			return nil, p.errorf("expected an argument number")
+		}
+		return &stdArg{Kind: kind, N: n}, nil
+	case c == '-' || '0' <= c && c <= '9':
+		n, ok := p.number()
+		if !ok {
+			return nil, p.errorf("expected an integer")
+		}
+		return &MExprInteger{Value: n}, nil
+	case c == '$' || 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z':
+		start := p.pos
+		for p.pos < len(p.s) && isSymbolChar(p.s[p.pos]) {
+			p.pos++
+		}
+		name := p.s[start:p.pos]
+		if i := strings.LastIndex(name, "`"); i >= 0 {
+			return newSymbol(name[:i], name[i+1:]), nil
+		}
+		return newSymbol("System", name), nil
+	}
+	return nil, p.errorf("unexpected %q", p.s[p.pos])
+}
+
+// number parses a decimal integer, which may be negative.
+func (p *templateParser) number() (int, bool) {
+	start := p.pos
+	if p.pos < len(p.s) && p.s[p.pos] == '-' {
+		p.pos++
+	}
+	for p.pos < len(p.s) && '0' <= p.s[p.pos] && p.s[p.pos] <= '9' {
+		p.pos++
+	}
+	n, err := strconv.Atoi(p.s[start:p.pos])
+	return n, err == nil
+}
+
+func isSymbolChar(c byte) bool {
+	return c == '$' || c == '`' || 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9'
+}
+
+// stdlibTable is the mapping that NewStdMapping starts from.
+const stdlibTable = `
+strings.Contains StringContainsQ[#1, #2]
+strings.ContainsAny StringContainsQ[#1, Characters[#2]]
+strings.Count StringCount[#1, #2]
+strings.EqualFold SameQ[ToLowerCase[#1], ToLowerCase[#2]]
+strings.Fields StringSplit[#1]
+strings.HasPrefix StringStartsQ[#1, #2]
+strings.HasSuffix StringEndsQ[#1, #2]
+strings.Join StringRiffle[#1, #2]
+strings.Repeat StringRepeat[#1, #2]
+strings.ReplaceAll StringReplace[#1, #2 -> #3]
+strings.Split StringSplit[#1, #2, All]
+strings.ToLower ToLowerCase[#1]
+strings.ToUpper ToUpperCase[#1]
+strings.TrimPrefix StringDelete[#1, StringExpression[StartOfString, #2]]
+strings.TrimSpace StringTrim[#1]
+strings.TrimSuffix StringDelete[#1, StringExpression[#2, EndOfString]]
+
+strconv.Atoi Interpreter["Integer"][#1]
+strconv.FormatBool ToLowerCase[ToString[#1]]
+strconv.Itoa ToString[#1]
+strconv.ParseBool Interpreter["Boolean"][#1]
+strconv.ParseFloat Interpreter["Number"][#1]
+strconv.Quote ToString[#1, InputForm]
+
+errors.New Failure["GoError", Association["Message" -> #1]]
+
+// Only formats with %v, %s and %d; see goTemplate.
+fmt.Errorf Failure["GoError", Association["Message" -> TemplateApply[StringTemplate[%1], {##2}]]]
+fmt.Print WriteString[$Output, StringJoin[Map[ToString, {##1}]]]
+fmt.Printf WriteString[$Output, TemplateApply[StringTemplate[%1], {##2}]]
+fmt.Println Print[StringRiffle[Map[ToString, {##1}], " "]]
+fmt.Sprint StringJoin[Map[ToString, {##1}]]
+fmt.Sprintf TemplateApply[StringTemplate[%1], {##2}]
+fmt.Sprintln StringJoin[StringRiffle[Map[ToString, {##1}], " "], "\n"]
+
+math.Abs Abs[#1]
+math.Acos ArcCos[#1]
+math.Asin ArcSin[#1]
+math.Atan ArcTan[#1]
+math.Atan2 ArcTan[#2, #1]
+math.Ceil Ceiling[#1]
+math.Cos Cos[#1]
+math.E E
+math.Exp Exp[#1]
+math.Floor Floor[#1]
+math.Hypot Sqrt[Plus[Power[#1, 2], Power[#2, 2]]]
+math.IsNaN SameQ[#1, Indeterminate]
+math.Ln2 Log[2]
+math.Log Log[#1]
+math.Log10 Log10[#1]
+math.Log2 Log2[#1]
+math.Max Max[#1, #2]
+math.MaxInt32 2147483647
+math.MaxInt64 9223372036854775807
+math.Min Min[#1, #2]
+math.MinInt32 -2147483648
+math.MinInt64 -9223372036854775808
+math.NaN Indeterminate
+math.Pi Pi
+math.Pow Power[#1, #2]
+math.Sin Sin[#1]
+math.Sqrt Sqrt[#1]
+math.Sqrt2 Sqrt[2]
+math.Tan Tan[#1]
+math.Trunc IntegerPart[#1]
+
+// Go sorts strings by their bytes, not in the kernel's canonical order.
+sort.Float64s Set[#1, Sort[#1]]
+sort.Float64sAreSorted OrderedQ[#1]
+sort.Ints Set[#1, Sort[#1]]
+sort.IntsAreSorted OrderedQ[#1]
+sort.Strings Set[#1, Sort[#1, Function[GreaterEqual[LexicographicOrder[ToCharacterCode[Slot[1], "UTF8"], ToCharacterCode[Slot[2], "UTF8"]], 0]]]]
+
+bytes.Compare Minus[LexicographicOrder[#1, #2]]
+bytes.Contains SequenceContainsQ[#1, #2]
+bytes.Equal SameQ[#1, #2]
+bytes.Join Apply[Join, Riffle[#1, {#2}]]
+bytes.Repeat Flatten[ConstantArray[#1, #2]]
+`
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A SymbolTable names the Wolfram symbols that stand for the Go
+// identifiers of a package.  The symbols live in the package's own
+// context, such as llvm`Private`, so that they cannot be confused
+// with System symbols, and their names are mangled to be valid
+// Wolfram symbols: each _ becomes $, and a name that is also a
+// System name gets a $ appended, as does any name that the mangling
+// of an earlier identifier already took.  The mapping therefore depends
+// on the order in which identifiers are first seen, which Declare makes
+// source order.  A local that shadows another declaration of its name
+// gets a symbol of its own, mangled the same way.
+type SymbolTable struct {
+	Context string // such as "llvm`Private", without the final `
+
+	mu      sync.Mutex
+	symbols map[string]string    // by Go identifier
+	idents  map[string]string    // by symbol name
+	shadows map[token.Pos]string // by position of the shadowing declaration
+}
+
+// NewSymbolTable returns an empty table for the context ctx,
+// which may be given with or without its final `.
+func NewSymbolTable(ctx string) *SymbolTable {
+	return &SymbolTable{
+		Context: strings.TrimSuffix(ctx, "`"),
+		symbols: make(map[string]string),
+		idents:  make(map[string]string),
+		shadows: make(map[token.Pos]string),
+	}
+}
+
+// Symbol returns the name of the symbol for the Go identifier name.
+func (t *SymbolTable) Symbol(name string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.symbol(name)
+}
+
+func (t *SymbolTable) symbol(name string) string {
+	if sym, ok := t.symbols[name]; ok {
+		return sym
+	}
+	sym := strings.Replace(name, "_", "$", -1)
+	if systemNames[sym] {
+		sym += "$"
+	}
+	for t.idents[sym] != "" {
+		sym += "$"
+	}
+	t.symbols[name] = sym
+	t.idents[sym] = name
+	return sym
+}
+
+// Shadow gives the local name declared at pos, which shadows another
+// declaration of name, a symbol distinct from that of the identifier.
+func (t *SymbolTable) Shadow(pos token.Pos, name string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if sym, ok := t.shadows[pos]; ok {
+		return sym
+	}
+	sym := t.symbol(name)
+	for t.idents[sym] != "" {
+		sym += "$"
+	}
+	t.shadows[pos] = sym
+	t.idents[sym] = name
+	return sym
+}
+
+// Local returns the symbol that Shadow gave the local declared
+// at pos, if any.
+func (t *SymbolTable) Local(pos token.Pos) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sym, ok := t.shadows[pos]
+	return sym, ok
+}
+
+// MExpr returns the table as
+// Rasta`Symbols[<|ctx`sym -> "ident", ...|>], sorted by identifier,
+// for mapping translated code back to the Go it came from.
+func (t *SymbolTable) MExpr() MExpr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	syms := make([]string, 0, len(t.idents))
+	for sym := range t.idents {
+		syms = append(syms, sym)
+	}
+	sort.Slice(syms, func(i, j int) bool {
+		if a, b := t.idents[syms[i]], t.idents[syms[j]]; a != b {
+			return a < b
+		}
+		return len(syms[i]) < len(syms[j]) || len(syms[i]) == len(syms[j]) && syms[i] < syms[j]
+	})
+	rules := make([]MExpr, len(syms))
+	for i, sym := range syms {
+		rules[i] = newRule(newSymbol(t.Context, sym), &MExprString{Value: t.idents[sym]})
+	}
+	return newNormal(newSymbol("Rasta", "Symbols"), newAssociation(rules...))
+}
+
+// Declare enters the identifiers of files into the Generator's symbol
+// table in the order in which they appear in the source, and then the
+// locals that shadow other declarations, as declareShadows finds them.
+func (this *Generator) Declare(files ...*ast.File) {
+	for _, f := range files {
+		this.declare(f)
+	}
+	this.declareShadows(files)
+}
+
+func (this *Generator) declare(f *ast.File) {
+	var declare func(n ast.Node) bool
+	declare = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			// The names in C are cgo's, and a selected
+			// name is never a predeclared one.
+			if this.isC(n.X) {
+				return false
+			}
+			ast.Inspect(n.X, declare)
+			this.Symbols.Symbol(n.Sel.Name)
+			return false
+		case *ast.Ident:
+			if n.Name != "_" && !this.isPredeclared(n) {
+				this.Symbols.Symbol(n.Name)
+			}
+		}
+		return true
+	}
+	for _, decl := range f.Decls {
+		ast.Inspect(decl, declare)
+	}
+}
+
+// declareShadows gives a symbol of its own to each local variable or
+// constant of files that shadows a declaration of the same name in an
+// enclosing scope or at package level.  Module makes the locals of a
+// block local to all of it, so that otherwise the x of x := x, or an
+// x used in the block before it declares its own, would be the new x.
+// The identifiers resolved by the parser tell the declarations apart.
+func (this *Generator) declareShadows(files []*ast.File) {
+	pkg := make(map[string]bool)
+	for _, f := range files {
+		for name := range f.Scope.Objects {
+			pkg[name] = true
+		}
+	}
+	// The locals declared so far, with the scope of each.
+	type local struct {
+		name  string
+		scope ast.Node
+	}
+	var locals []local
+	for _, f := range files {
+		var stack []ast.Node
+		ast.Inspect(f, func(n ast.Node) bool {
+			if n == nil {
+				stack = stack[:len(stack)-1]
+				return true
+			}
+			id, ok := n.(*ast.Ident)
+			if ok && id.Obj != nil && (id.Obj.Kind == ast.Var || id.Obj.Kind == ast.Con) && id.Obj.Pos() == id.Pos() && id.Name != "_" {
+				if scope := innermostScope(stack); scope != nil {
+					shadows := pkg[id.Name]
+					for _, l := range locals {
+						if l.name == id.Name && l.scope != scope && l.scope.Pos() <= id.Pos() && id.Pos() < l.scope.End() {
+							shadows = true
+						}
+					}
+					if shadows {
+						this.Symbols.Shadow(id.Pos(), id.Name)
+					}
+					locals = append(locals, local{id.Name, scope})
+				}
+			}
+			stack = append(stack, n)
+			return true
+		})
+	}
+}
+
+// innermostScope returns the innermost of the nodes on stack that
+// opens a scope, or nil if there is none and so the package scope.
+func innermostScope(stack []ast.Node) ast.Node {
+	for i := len(stack) - 1; i >= 0; i-- {
+		switch stack[i].(type) {
+		case *ast.BlockStmt, *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt,
+			*ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.CaseClause, *ast.CommClause,
+			*ast.FuncDecl, *ast.FuncLit:
+			return stack[i]
+		}
+	}
+	return nil
+}
+
+// symbol returns the symbol for the identifier id.  Without a symbol
+// table, that is the System symbol of the same name.  With one,
+// predeclared identifiers, like nil, true, len and append, are the
+// Rasta symbols Rasta`Nil, Rasta`True, Rasta`Len and Rasta`Append,
+// and the others are those of the table.
+func (this *Generator) symbol(id *ast.Ident) *MExprSymbol {
+	if this.Symbols != nil && this.isPredeclared(id) {
+		return &MExprSymbol{
+			MExprBase: MExprBase{
+				Position: id.Pos(),
+			},
+			Context: "Rasta",
+			Name:    strings.ToUpper(id.Name[:1]) + id.Name[1:],
+		}
+	}
+	return this.name(id)
+}
+
+// name is like symbol, for identifiers that cannot be predeclared
+// ones, such as the field and method names of selectors.  The blank
+// identifier, which names nothing, is Null.
+func (this *Generator) name(id *ast.Ident) *MExprSymbol {
+	if id.Name == "_" {
+		return &MExprSymbol{
+			MExprBase: MExprBase{
+				Position: id.Pos(),
+			},
+			Context: "System",
+			Name:    "Null",
+		}
+	}
+	if this.Symbols != nil && id.Obj != nil {
+		if sym, ok := this.Symbols.Local(id.Obj.Pos()); ok {
+			return &MExprSymbol{
+				MExprBase: MExprBase{
+					Position: id.Pos(),
+				},
+				Context: this.Symbols.Context,
+				Name:    sym,
+			}
+		}
+	}
+	if this.Symbols == nil {
+		return &MExprSymbol{
+			MExprBase: MExprBase{
+				Position: id.Pos(),
+			},
+			Context: "System",
+			Name:    id.Name,
+		}
+	}
+	return &MExprSymbol{
+		MExprBase: MExprBase{
+			Position: id.Pos(),
+		},
+		Context: this.Symbols.Context,
+		Name:    this.Symbols.Symbol(id.Name),
+	}
+}
+
+// isPredeclared reports whether id refers to one of Go's predeclared
+// identifiers.  Without types, it must not have been declared in the
+// file, as the parser finds, and so might in rare cases be a name
+// declared in another file of the package instead.
+func (this *Generator) isPredeclared(id *ast.Ident) bool {
+	if this.Info != nil {
+		if obj := this.Info.Uses[id]; obj != nil {
+			return obj.Parent() == types.Universe
+		}
+		if this.Info.Defs[id] != nil {
+			return false
+		}
+	}
+	return id.Obj == nil && types.Universe.Lookup(id.Name) != nil
+}
+
+// systemNames lists System symbols that Go identifiers are likely to
+// be named like.  The symbols of a SymbolTable are in their own
+// context and cannot clash with these anyway; avoiding their names
+// keeps the translation readable when the context is left off.
+var systemNames = map[string]bool{
+	"Abs": true, "All": true, "And": true, "Append": true, "Apply": true,
+	"Array": true, "Association": true, "Attributes": true, "Automatic": true,
+	"Begin": true, "BeginPackage": true, "Block": true, "Break": true,
+	"Byte": true, "C": true, "Cases": true, "Catch": true, "Ceiling": true,
+	"Character": true, "Check": true, "Circle": true, "Clear": true,
+	"Close": true, "Compile": true, "Complex": true, "CompoundExpression": true,
+	"Context": true, "Continue": true, "Cos": true, "Count": true, "D": true,
+	"Default": true, "Delete": true, "Depth": true, "Disk": true, "Do": true,
+	"Drop": true, "E": true, "Echo": true, "End": true, "EndPackage": true,
+	"Equal": true, "Evaluate": true, "Exit": true, "Exp": true, "Export": true,
+	"False": true, "First": true, "Floor": true, "Fold": true, "For": true,
+	"Format": true, "Function": true, "Get": true, "Goto": true, "Graph": true,
+	"Greater": true, "Head": true, "Hold": true, "I": true, "If": true,
+	"Image": true, "Import": true, "Indeterminate": true, "Infinity": true,
+	"Install": true, "Integer": true, "Interrupt": true, "Join": true,
+	"K": true, "Key": true, "Keys": true, "Label": true, "Last": true,
+	"Length": true, "Less": true, "Level": true, "Line": true, "List": true,
+	"Log": true, "Map": true, "Max": true, "Message": true, "Min": true,
+	"Missing": true, "Mod": true, "Module": true, "N": true, "Names": true,
+	"Needs": true, "Nest": true, "None": true, "Not": true, "Null": true,
+	"Number": true, "O": true, "Open": true, "Options": true, "Or": true,
+	"Part": true, "Pattern": true, "Pause": true, "Pi": true, "Plus": true,
+	"Point": true, "Position": true, "Power": true, "Print": true,
+	"Product": true, "Protect": true, "Quit": true, "Quotient": true,
+	"Range": true, "Rational": true, "Read": true, "Real": true,
+	"Remove": true, "Replace": true, "Rest": true, "Return": true,
+	"Reverse": true, "Round": true, "Rule": true, "Run": true, "Scan": true,
+	"Select": true, "Sequence": true, "Set": true, "Short": true, "Sin": true,
+	"Slot": true, "Sort": true, "Sqrt": true, "String": true, "Style": true,
+	"Sum": true, "Switch": true, "Symbol": true, "Table": true, "Take": true,
+	"Tan": true, "Text": true, "Throw": true, "Times": true, "Total": true,
+	"True": true, "Unique": true, "Unprotect": true, "Values": true,
+	"Which": true, "While": true, "With": true, "Write": true, "Xor": true,
+}
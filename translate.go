@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/abduld/rasta/cgo"
+)
+
+func translateUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprint(os.Stderr, "usage: rasta translate [-targets goos/goarch,...] [-objdir dir] [-lib library] [-allenums] [-parseheaders] [-reproducible [-trimpath prefixes]] -- [compiler options] file.go ...\n")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+}
+
+// translateMain implements "rasta translate".  It translates the input
+// files once for each target, with the C compiler set up for that target,
+// and writes each target's output to its own goos_goarch subdirectory
+// of the object directory.  It then reports the C declarations whose
+// size, alignment or field offsets differ between the targets, as text
+// on standard output and as Wolfram code in _rasta_layouts.wl.
+func translateMain(args []string) {
+	fs := flag.NewFlagSet("translate", flag.ExitOnError)
+	targets := fs.String("targets", runtime.GOOS+"/"+runtime.GOARCH, "comma-separated list of goos/goarch targets to translate for")
+	objDir := fs.String("objdir", "_obj", "write the output for each target to a goos_goarch subdirectory of this directory")
+	lib := fs.String("lib", "", "library to load C functions from with ForeignFunctionLoad (default Rasta`$CLibrary)")
+	all := fs.Bool("allenums", false, "describe every C enum in the headers, not just the referenced ones")
+	parse := fs.Bool("parseheaders", false, "classify C names by parsing the headers instead of compiling probe programs")
+	reproducible := fs.Bool("reproducible", false, "make the output independent of where and how often it is generated")
+	trimPath := fs.String("trimpath", "", "with -reproducible, remove these ;-separated prefixes from recorded file names")
+	fs.Usage = translateUsage(fs)
+	fs.Parse(args)
+
+	// As for godefs, everything before the first Go file
+	// is an option for the C compiler.
+	args = fs.Args()
+	var i int
+	for i = len(args); i > 0; i-- {
+		if !strings.HasSuffix(args[i-1], ".go") {
+			break
+		}
+	}
+	if i == len(args) {
+		fs.Usage()
+	}
+	goFiles := args[i:]
+
+	var layouts []*targetLayout
+	for _, target := range strings.Split(*targets, ",") {
+		goos, goarch, ok := splitTarget(target)
+		if !ok {
+			fatalf("invalid target %q: want goos/goarch", target)
+		}
+		if cgo.LookupTarget(goarch) == nil {
+			fatalf("unknown architecture %q in target %q: want one of %s", goarch, target, strings.Join(cgo.TargetArchs(), ", "))
+		}
+		dir := filepath.Join(*objDir, goos+"_"+goarch)
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			fatalf("%s", err)
+		}
+		p := cgo.NewPackage(args[:i], cgo.Options{
+			ObjDir:       dir,
+			GOOS:         goos,
+			GOARCH:       goarch,
+			Reproducible: *reproducible,
+			TrimPath:     *trimPath,
+		})
+		p.AllEnums = *all
+		p.ParseHeaders = *parse
+		for i, f := range readFiles(p, goFiles) {
+			input := goFiles[i]
+			p.Translate(f)
+			p.PackagePath = f.Package
+			p.Record(f)
+			p.WriteOutput(f, input)
+		}
+		p.WriteDefs()
+		writeWolfram(p, *lib)
+		layouts = append(layouts, collectLayouts(goos+"/"+goarch, p))
+	}
+
+	diffs := layoutDiffs(layouts)
+	os.Stdout.WriteString(layoutReport(layouts, diffs))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "(* Created by rasta translate - DO NOT EDIT *)\n\n")
+	fmt.Fprintf(&buf, "%s\n", layoutsMExpr(layouts, diffs))
+	if err := ioutil.WriteFile(filepath.Join(*objDir, "_rasta_layouts.wl"), buf.Bytes(), 0666); err != nil {
+		fatalf("%s", err)
+	}
+}
+
+// splitTarget splits a target of the form goos/goarch.
+func splitTarget(target string) (goos, goarch string, ok bool) {
+	i := strings.Index(target, "/")
+	if i <= 0 || i == len(target)-1 || strings.Contains(target[i+1:], "/") {
+		return "", "", false
+	}
+	return target[:i], target[i+1:], true
+}
+
+// A targetLayout records how one target lays out the C types
+// that a translation used, keyed by their C names.
+type targetLayout struct {
+	Target string // goos/goarch
+	Types  map[string]*cLayout
+}
+
+// A cLayout is the layout of a C type on one target.
+type cLayout struct {
+	Size   int64
+	Align  int64
+	Fields []fieldOffset // for a struct or union, in declaration order
+}
+
+// A fieldOffset is the position of a struct or union field.  The fields of
+// an anonymous struct or union member are listed in place of the member,
+// at their offsets within the enclosing type.
+type fieldOffset struct {
+	Name      string
+	Offset    int64
+	BitOffset int64 // for a bit-field, as in cgo.StructField
+	BitSize   int64
+}
+
+func (f fieldOffset) String() string {
+	if f.BitSize > 0 {
+		return fmt.Sprintf("%d:%d", f.Offset, f.BitOffset)
+	}
+	return fmt.Sprint(f.Offset)
+}
+
+// collectLayouts records the layouts of the C struct and union types
+// that p laid out, and of the C types it referred to by name.
+func collectLayouts(target string, p *cgo.Package) *targetLayout {
+	tl := &targetLayout{Target: target, Types: make(map[string]*cLayout)}
+	// Anonymous struct and union members have no C name of
+	// their own; their fields are listed in the enclosing type.
+	members := make(map[*cgo.Type]bool)
+	for _, name := range cgo.TypedefNames() {
+		for _, f := range cgo.LookupTypedef(name).Fields {
+			if f.Name == "" {
+				members[f.Type] = true
+			}
+		}
+	}
+	for _, name := range cgo.TypedefNames() {
+		t := cgo.LookupTypedef(name)
+		if t.Fields == nil || members[t] {
+			continue
+		}
+		tl.Types[t.C.String()] = &cLayout{Size: t.Size, Align: t.Align, Fields: flattenFields(nil, 0, t)}
+	}
+	for _, key := range sortedNames(p.Name) {
+		n := p.Name[key]
+		if n.Kind != "type" || n.Type == nil {
+			continue
+		}
+		if _, ok := tl.Types[n.C]; !ok {
+			tl.Types[n.C] = &cLayout{Size: n.Type.Size, Align: n.Type.Align}
+		}
+	}
+	return tl
+}
+
+func flattenFields(fields []fieldOffset, base int64, t *cgo.Type) []fieldOffset {
+	for _, f := range t.Fields {
+		if f.Name == "" && f.Type.Fields != nil {
+			fields = flattenFields(fields, base+f.Offset, f.Type)
+			continue
+		}
+		fields = append(fields, fieldOffset{Name: f.Name, Offset: base + f.Offset, BitOffset: f.BitOffset, BitSize: f.BitSize})
+	}
+	return fields
+}
+
+// layoutDiffs returns the sorted C names of the types whose layout is
+// not the same on all targets, including those missing on some targets.
+func layoutDiffs(layouts []*targetLayout) []string {
+	seen := make(map[string]bool)
+	var diffs []string
+	for _, tl := range layouts {
+		for name := range tl.Types {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			for _, other := range layouts {
+				if !reflect.DeepEqual(tl.Types[name], other.Types[name]) {
+					diffs = append(diffs, name)
+					break
+				}
+			}
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+// layoutReport describes the differences in diffs as text, listing
+// for each type only the properties on which the targets disagree.
+func layoutReport(layouts []*targetLayout, diffs []string) string {
+	var buf bytes.Buffer
+	targets := make([]string, len(layouts))
+	for i, tl := range layouts {
+		targets[i] = tl.Target
+	}
+	if len(diffs) == 0 {
+		fmt.Fprintf(&buf, "no layout differences between %s\n", strings.Join(targets, ", "))
+		return buf.String()
+	}
+	fmt.Fprintf(&buf, "layout differences between %s:\n", strings.Join(targets, ", "))
+	for _, name := range diffs {
+		fmt.Fprintf(&buf, "%s\n", name)
+		for _, tl := range layouts {
+			if tl.Types[name] == nil {
+				fmt.Fprintf(&buf, "\t%s: not declared\n", tl.Target)
+			}
+		}
+		props := []string{"size", "align"}
+		props = append(props, fieldNames(layouts, name)...)
+		for _, prop := range props {
+			var vals []string
+			first, differ := "", false
+			for _, tl := range layouts {
+				l := tl.Types[name]
+				if l == nil {
+					continue
+				}
+				v := layoutProperty(l, prop)
+				if len(vals) == 0 {
+					first = v
+				} else if v != first {
+					differ = true
+				}
+				vals = append(vals, tl.Target+" "+v)
+			}
+			if differ {
+				fmt.Fprintf(&buf, "\t%s: %s\n", prop, strings.Join(vals, ", "))
+			}
+		}
+	}
+	return buf.String()
+}
+
+// layoutProperty returns the size, the alignment, or the offset of
+// the named field of l as text, or "-" if l has no such field.
+func layoutProperty(l *cLayout, prop string) string {
+	switch prop {
+	case "size":
+		return fmt.Sprint(l.Size)
+	case "align":
+		return fmt.Sprint(l.Align)
+	}
+	for _, f := range l.Fields {
+		if f.Name == prop {
+			return f.String()
+		}
+	}
+	return "-"
+}
+
+// fieldNames returns the names of the fields of the type name on
+// any of the targets, in the order in which they are first seen.
+func fieldNames(layouts []*targetLayout, name string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, tl := range layouts {
+		l := tl.Types[name]
+		if l == nil {
+			continue
+		}
+		for _, f := range l.Fields {
+			if !seen[f.Name] {
+				seen[f.Name] = true
+				names = append(names, f.Name)
+			}
+		}
+	}
+	return names
+}
+
+// layoutsMExpr describes the types in diffs as
+//
+//	Rasta`LayoutDifferences[<|"struct stat" -> <|
+//		"linux/amd64" -> <|"Size" -> 144, "Align" -> 8, "Offsets" -> <|"st_dev" -> 0, ...|>|>,
+//		"linux/386" -> <|"Size" -> 88, ...|>, ...|>, ...|>]
+//
+// The offset of a bit-field is {offset, bitOffset}, and a type
+// missing on a target is Missing["NotDeclared"] there.
+func layoutsMExpr(layouts []*targetLayout, diffs []string) MExpr {
+	var types []MExpr
+	for _, name := range diffs {
+		var perTarget []MExpr
+		for _, tl := range layouts {
+			var desc MExpr = newNormal(newSymbol("System", "Missing"), &MExprString{Value: "NotDeclared"})
+			if l := tl.Types[name]; l != nil {
+				offsets := make([]MExpr, len(l.Fields))
+				for i, f := range l.Fields {
+					var off MExpr = &MExprInteger{Value: int(f.Offset)}
+					if f.BitSize > 0 {
+						off = newList(off, &MExprInteger{Value: int(f.BitOffset)})
+					}
+					offsets[i] = newRule(&MExprString{Value: f.Name}, off)
+				}
+				desc = newAssociation(
+					newRule(&MExprString{Value: "Size"}, &MExprInteger{Value: int(l.Size)}),
+					newRule(&MExprString{Value: "Align"}, &MExprInteger{Value: int(l.Align)}),
+					newRule(&MExprString{Value: "Offsets"}, newAssociation(offsets...)),
+				)
+			}
+			perTarget = append(perTarget, newRule(&MExprString{Value: tl.Target}, desc))
+		}
+		types = append(types, newRule(&MExprString{Value: name}, newAssociation(perTarget...)))
+	}
+	return newNormal(newSymbol("Rasta", "LayoutDifferences"), newAssociation(types...))
+}
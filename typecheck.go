@@ -0,0 +1,184 @@
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+
+	"github.com/abduld/rasta/cgo"
+)
+
+// typeCheck type-checks files, which make up one package, and returns
+// what it found out about their expressions, as far as it got.  Names
+// in the pseudo-package C are those that cgo resolved in p, which may
+// be nil if the files do not import "C".  Type errors do not stop the
+// checking; they are returned.
+func typeCheck(fset *token.FileSet, files []*ast.File, p *cgo.Package) (*types.Info, []error) {
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+	}
+	name := ""
+	if len(files) > 0 {
+		name = files[0].Name.Name
+	}
+	pkg := types.NewPackage(name, name)
+	var errs []error
+	conf := &types.Config{
+		Importer: &cImporter{p: p, home: pkg, base: importer.ForCompiler(fset, "source", nil)},
+		Error: func(err error) {
+			// C's names are lower case as often as not,
+			// but cgo lets Go refer to them all the same.
+			if terr, ok := err.(types.Error); ok && strings.HasSuffix(terr.Msg, "not exported by package C") {
+				return
+			}
+			errs = append(errs, err)
+		},
+	}
+	types.NewChecker(conf, fset, pkg, info).Files(files)
+	return info, errs
+}
+
+// A cImporter imports the pseudo-package C, made from the names that
+// cgo resolved in p, and other packages with base.
+type cImporter struct {
+	p    *cgo.Package
+	home *types.Package // the package importing C, which may use the fields of C's structs
+	base types.Importer
+
+	c     *types.Package
+	named map[string]*types.Named // by cgo's Go name, such as _Ctype_int
+}
+
+func (imp *cImporter) Import(path string) (*types.Package, error) {
+	if path != "C" {
+		return imp.base.Import(path)
+	}
+	if imp.c == nil {
+		imp.c = types.NewPackage("C", "C")
+		imp.named = make(map[string]*types.Named)
+		if imp.p != nil {
+			imp.declare()
+		}
+		imp.c.MarkComplete()
+	}
+	return imp.c, nil
+}
+
+// declare declares the C names of imp.p in imp.c.
+func (imp *cImporter) declare() {
+	scope := imp.c.Scope()
+	for _, key := range sortedNames(imp.p.Name) {
+		n := imp.p.Name[key]
+		var obj types.Object
+		switch n.Kind {
+		case "type":
+			typ := imp.goType(n.Type.Go)
+			if named, ok := typ.(*types.Named); ok && named.Obj().Name() == key {
+				obj = named.Obj()
+			} else {
+				obj = types.NewTypeName(token.NoPos, imp.c, key, typ)
+			}
+		case "func":
+			if n.FuncType == nil {
+				continue
+			}
+			obj = types.NewFunc(token.NoPos, imp.c, key, imp.signature(n.FuncType.Go))
+		case "var", "fpvar":
+			obj = types.NewVar(token.NoPos, imp.c, key, imp.goType(n.Type.Go))
+		case "iconst", "fconst", "sconst", "const":
+			// Like cgo's _Ciconst_ names, C constants are untyped.
+			tv, err := types.Eval(token.NewFileSet(), nil, token.NoPos, n.Const)
+			if err != nil || tv.Value == nil {
+				continue
+			}
+			obj = types.NewConst(token.NoPos, imp.c, key, tv.Type, tv.Value)
+		default:
+			continue
+		}
+		scope.Insert(obj)
+	}
+}
+
+// goType returns the type that cgo's Go spelling x of a C type denotes.
+// The types that cgo names _Ctype_foo become named types foo in package C.
+func (imp *cImporter) goType(x ast.Expr) types.Type {
+	switch x := x.(type) {
+	case *ast.Ident:
+		if strings.HasPrefix(x.Name, "_Ctype_") {
+			return imp.namedType(x.Name)
+		}
+		if obj, ok := types.Universe.Lookup(x.Name).(*types.TypeName); ok {
+			return obj.Type()
+		}
+	case *ast.SelectorExpr:
+		if id, ok := x.X.(*ast.Ident); ok && id.Name == "unsafe" && x.Sel.Name == "Pointer" {
+			return types.Typ[types.UnsafePointer]
+		}
+	case *ast.StarExpr:
+		return types.NewPointer(imp.goType(x.X))
+	case *ast.ArrayType:
+		if x.Len == nil {
+			return types.NewSlice(imp.goType(x.Elt))
+		}
+		if lit, ok := x.Len.(*ast.BasicLit); ok {
+			if n, err := strconv.ParseInt(lit.Value, 0, 64); err == nil {
+				return types.NewArray(imp.goType(x.Elt), n)
+			}
+		}
+	case *ast.StructType:
+		var fields []*types.Var
+		for _, f := range x.Fields.List {
+			typ := imp.goType(f.Type)
+			for _, name := range f.Names {
+				fields = append(fields, types.NewField(token.NoPos, imp.home, name.Name, typ, false))
+			}
+		}
+		return types.NewStruct(fields, nil)
+	case *ast.FuncType:
+		return imp.signature(x)
+	}
+	return types.Typ[types.Invalid]
+}
+
+// namedType returns the named type in package C for cgo's type name,
+// such as _Ctype_struct_foo, defined as cgo defined it.
+func (imp *cImporter) namedType(name string) *types.Named {
+	if t := imp.named[name]; t != nil {
+		return t
+	}
+	obj := types.NewTypeName(token.NoPos, imp.c, strings.TrimPrefix(name, "_Ctype_"), nil)
+	t := types.NewNamed(obj, nil, nil)
+	imp.named[name] = t
+	var under types.Type = types.NewStruct(nil, nil) // incomplete C types are opaque
+	if def := cgo.LookupTypedef(name); def != nil {
+		under = imp.goType(def.Go).Underlying()
+	}
+	t.SetUnderlying(under)
+	return t
+}
+
+func (imp *cImporter) signature(ft *ast.FuncType) *types.Signature {
+	tuple := func(fl *ast.FieldList) *types.Tuple {
+		if fl == nil {
+			return nil
+		}
+		var vars []*types.Var
+		for _, f := range fl.List {
+			typ := imp.goType(f.Type)
+			if len(f.Names) == 0 {
+				vars = append(vars, types.NewParam(token.NoPos, imp.c, "", typ))
+			}
+			for _, name := range f.Names {
+				vars = append(vars, types.NewParam(token.NoPos, imp.c, name.Name, typ))
+			}
+		}
+		return types.NewTuple(vars...)
+	}
+	return types.NewSignatureType(nil, nil, nil, tuple(ft.Params), tuple(ft.Results), false)
+}